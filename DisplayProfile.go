@@ -0,0 +1,210 @@
+package deejdsp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jax-b/deejdsp/bimage"
+	"github.com/jax-b/deejdsp/cimage"
+)
+
+// PageLayout is how the display controller expects the pages of an image to be written
+type PageLayout int
+
+// Supported page layouts
+const (
+	// LayoutHorizontal writes the whole image in one go using horizontal addressing mode
+	LayoutHorizontal PageLayout = iota
+	// LayoutPage writes the image one page at a time using page addressing mode
+	LayoutPage
+)
+
+// ParsePageLayout converts a config string into a PageLayout
+func ParsePageLayout(value string) (PageLayout, error) {
+	switch strings.ToLower(value) {
+	case "horizontal":
+		return LayoutHorizontal, nil
+	case "page":
+		return LayoutPage, nil
+	}
+	return LayoutHorizontal, fmt.Errorf("unknown page layout %q", value)
+}
+
+// String returns the config name of the layout
+func (layout PageLayout) String() string {
+	if layout == LayoutPage {
+		return "page"
+	}
+	return "horizontal"
+}
+
+// Controller is the chip driving a display
+type Controller int
+
+// Supported display controllers
+const (
+	// ControllerSSD1306 is the default controller, it has 128 columns of ram and supports horizontal addressing
+	ControllerSSD1306 Controller = iota
+	// ControllerSH1106 has 132 columns of ram with the panel starting at column 2 and only supports page addressing
+	ControllerSH1106
+	// ControllerSSD1331 drives 96x64 colour displays using .c files
+	ControllerSSD1331
+	// ControllerSSD1351 drives 128x128 colour displays using .c files
+	ControllerSSD1351
+)
+
+// sh1106ColumnOffset is where the 128 visible columns start in the 132 column ram of a SH1106
+const sh1106ColumnOffset = 2
+
+// ParseController converts a config string into a Controller
+func ParseController(value string) (Controller, error) {
+	switch strings.ToLower(value) {
+	case "ssd1306":
+		return ControllerSSD1306, nil
+	case "sh1106":
+		return ControllerSH1106, nil
+	case "ssd1331":
+		return ControllerSSD1331, nil
+	case "ssd1351":
+		return ControllerSSD1351, nil
+	}
+	return ControllerSSD1306, fmt.Errorf("unknown display controller %q", value)
+}
+
+// String returns the config name of the controller
+func (controller Controller) String() string {
+	switch controller {
+	case ControllerSH1106:
+		return "sh1106"
+	case ControllerSSD1331:
+		return "ssd1331"
+	case ControllerSSD1351:
+		return "ssd1351"
+	}
+	return "ssd1306"
+}
+
+// Columns returns how many columns of ram the controller has
+func (controller Controller) Columns() int {
+	if controller == ControllerSH1106 {
+		return 132
+	}
+	return 128
+}
+
+// Color reports if the controller drives a colour display
+func (controller Controller) Color() bool {
+	return controller == ControllerSSD1331 || controller == ControllerSSD1351
+}
+
+// DisplayProfile describes the panel connected to a display port
+// ColumnOffset is the first column of the controllers ram that is visible on the panel
+// Palette is the most colours a .c file for a colour display can use, 0 stores every pixel as RGB565
+type DisplayProfile struct {
+	Width        int
+	Height       int
+	Layout       PageLayout
+	ColumnOffset int
+	Controller   Controller
+	Palette      int
+}
+
+// DefaultDisplayProfile returns the profile of the 128x64 ssd1306 displays
+func DefaultDisplayProfile() DisplayProfile {
+	return DisplayProfile{
+		Width:  bimage.Width,
+		Height: bimage.Height,
+		Layout: LayoutHorizontal,
+	}
+}
+
+// ControllerProfile returns the profile of a 128x64 display using controller
+func ControllerProfile(controller Controller) DisplayProfile {
+	profile := DefaultDisplayProfile()
+	profile.Controller = controller
+	switch controller {
+	case ControllerSH1106:
+		profile.Layout = LayoutPage
+		profile.ColumnOffset = sh1106ColumnOffset
+	case ControllerSSD1331:
+		profile.Width, profile.Height = 96, 64
+	case ControllerSSD1351:
+		profile.Width, profile.Height = 128, 128
+	}
+	return profile
+}
+
+// IsDefault reports if the profile is the same as DefaultDisplayProfile
+func (profile DisplayProfile) IsDefault() bool {
+	return profile == DefaultDisplayProfile()
+}
+
+// Geometry returns the size of .b files for this display
+func (profile DisplayProfile) Geometry() bimage.Geometry {
+	return bimage.Geometry{Width: profile.Width, Height: profile.Height}
+}
+
+// ColorGeometry returns the size of .c files for this display
+func (profile DisplayProfile) ColorGeometry() cimage.Geometry {
+	return cimage.Geometry{Width: profile.Width, Height: profile.Height}
+}
+
+// Validate checks that images can be generated for the profile
+func (profile DisplayProfile) Validate() error {
+	if profile.Controller.Color() {
+		if err := profile.ColorGeometry().Validate(); err != nil {
+			return err
+		}
+		if profile.Palette < 0 || profile.Palette > cimage.MaxPaletteColors {
+			return fmt.Errorf("palette needs 0-%d colours, got %d", cimage.MaxPaletteColors, profile.Palette)
+		}
+		return nil
+	}
+	if err := profile.Geometry().Validate(); err != nil {
+		return err
+	}
+	if profile.ColumnOffset < 0 {
+		return fmt.Errorf("column offset cannot be negative, got %d", profile.ColumnOffset)
+	}
+	if columns := profile.Controller.Columns(); profile.ColumnOffset+profile.Width > columns {
+		return fmt.Errorf("column offset %d and width %d do not fit in the %d columns of the %s", profile.ColumnOffset, profile.Width, columns, profile.Controller)
+	}
+	if profile.Controller == ControllerSH1106 && profile.Layout != LayoutPage {
+		return fmt.Errorf("the sh1106 does not support %s addressing, use the page layout", profile.Layout)
+	}
+	return nil
+}
+
+// Key returns a short string describing the profile used to tell generated files apart
+// The default profile returns an empty string
+func (profile DisplayProfile) Key() string {
+	if profile.IsDefault() {
+		return ""
+	}
+	if profile.Controller.Color() {
+		// colour images use their own file extension, the palette changes the file
+		key := profile.ColorGeometry().String()
+		if profile.Palette > 0 {
+			key += fmt.Sprintf("p%d", profile.Palette)
+		}
+		return key
+	}
+	// the controller, column offset and layout only change how the image is sent, not the image itself
+	return profile.Geometry().String()
+}
+
+// ParseDisplaySize reads a size written as WIDTHxHEIGHT like 128x32
+func ParseDisplaySize(value string) (width int, height int, err error) {
+	parts := strings.Split(strings.ToLower(value), "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("display size %q needs to be WIDTHxHEIGHT", value)
+	}
+	if width, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil {
+		return 0, 0, fmt.Errorf("display size %q: %w", value, err)
+	}
+	if height, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+		return 0, 0, fmt.Errorf("display size %q: %w", value, err)
+	}
+	return width, height, nil
+}
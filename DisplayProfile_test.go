@@ -0,0 +1,32 @@
+package deejdsp
+
+import "testing"
+
+func TestDisplayProfileValidateColumns(t *testing.T) {
+	tests := []struct {
+		controller Controller
+		width      int
+		offset     int
+		valid      bool
+	}{
+		{ControllerSSD1306, 128, 0, true},
+		{ControllerSSD1306, 128, 2, false},
+		{ControllerSSD1306, 64, 64, true},
+		{ControllerSSD1306, 64, 65, false},
+		{ControllerSH1106, 128, 2, true},
+		{ControllerSH1106, 128, 4, true},
+		{ControllerSH1106, 128, 5, false},
+	}
+	for _, test := range tests {
+		profile := ControllerProfile(test.controller)
+		profile.Width = test.width
+		profile.ColumnOffset = test.offset
+		err := profile.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%s %d columns at %d: %s", test.controller, test.width, test.offset, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s %d columns at %d: expected an error", test.controller, test.width, test.offset)
+		}
+	}
+}
@@ -5,18 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"image"
-	"image/color"
 	"strings"
 
 	"github.com/jax-b/deej/pkg/deej"
+	"github.com/jax-b/deejdsp/cimage"
 	"github.com/jax-b/iconfinderapi"
 	"github.com/jax-b/ssd1306FilePrep"
-	"github.com/nfnt/resize"
-)
-
-const (
-	displaySizeX = 128
-	displaySizeY = 64
 )
 
 // GetIconFromAPI gets an icon from online
@@ -24,23 +18,35 @@ const (
 // It only looks up 3 icons
 // It filters on flat icons, it cannot be a icon that needs to be bought
 // It cannot be a vector image
+//
+// Deprecated: use IconfinderProvider, it ranks the results, backs off when rate limited and can use svgs
 func GetIconFromAPI(icofdr *iconfinderapi.Iconfinder, keyword string) (image.Image, error) {
+	return GetIconFromAPIWithProfile(icofdr, keyword, DefaultDisplayProfile())
+}
+
+// GetIconFromAPIWithProfile gets an icon from online like GetIconFromAPI
+// The icon has to be big enough to fill the display described by profile
+//
+// Deprecated: use IconfinderProvider, the configurable version of this used by the icon_providers config
+func GetIconFromAPIWithProfile(icofdr *iconfinderapi.Iconfinder, keyword string, profile DisplayProfile) (image.Image, error) {
 	search, err := icofdr.SearchIcons(keyword, 3, -1, 0, 0, "", "", "flat")
 	if err != nil {
 		return nil, err
 	}
 	for _, results := range search.Icons {
 		for _, size := range results.Rasters {
-			// Looks for the first icon that is equal to or bigger than the display size
+			// Looks for the first icon that would fill the display without being scaled up
 			// We resize it anyways so we are just looking for an icon with the most detail
-			if size.SizeHeight >= displaySizeY {
-				for _, format := range size.Formats {
-					if format.Format == "png" {
-						return icofdr.DownloadIcon(format), nil
-					}
-				}
-				// if we cannot find a png (preferential) then we download the jpg
-				return icofdr.DownloadIcon(size.Formats[0]), nil
+			if size.SizeHeight < profile.Height && size.SizeWidth < profile.Width {
+				continue
+			}
+			// the iconfinderapi downloader only decodes pngs, it returns nil for anything else or a failed download
+			format, ok := preferredFormat(size.Formats, "png")
+			if !ok {
+				continue
+			}
+			if img := icofdr.DownloadIcon(format); img != nil {
+				return img, nil
 			}
 		}
 	}
@@ -50,41 +56,118 @@ func GetIconFromAPI(icofdr *iconfinderapi.Iconfinder, keyword string) (image.Ima
 
 // ConvertImage returns a byteslice with the converted image
 // Basicly a copy of the main test program in my ssd1306 file prep lib but we dont write it to a file
+// threshold can be ThresholdOtsu or ThresholdAdaptive to let the image decide its own threshold
 func ConvertImage(srcimg image.Image, index int32, threshold int) ([][]byte, error) {
+	opts := DefaultConvertOptions()
+	opts.Threshold = threshold
+	return ConvertImageWithOptions(srcimg, opts)
+}
+
+// ConvertImageWithOptions returns a byteslice with the converted image
+// opts controls how the image is scaled and positioned and the pipeline used to convert it to black and white
+func ConvertImageWithOptions(srcimg image.Image, opts ConvertOptions) ([][]byte, error) {
 	if srcimg == nil {
 		return nil, errors.New("srcimg equal to nil")
 	}
-	resizedImage := resize.Thumbnail(uint(displaySizeX), uint(displaySizeY), srcimg, resize.NearestNeighbor)
+	profile := opts.Profile
+	if profile.Width == 0 && profile.Height == 0 {
+		profile = DefaultDisplayProfile()
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
 
-	amountToCenter := (displaySizeX - resizedImage.Bounds().Max.X) / 2
+	// displays mounted on their side need the icon laid out for the rotated screen
+	canvasX, canvasY := profile.Width, profile.Height
+	if opts.Transform.swapsAxes() {
+		canvasX, canvasY = canvasY, canvasX
+	}
+	constructedImage, iconArea := layoutImage(srcimg, opts, canvasX, canvasY)
 
-	constructedImage := image.NewRGBA(image.Rect(0, 0, displaySizeX, displaySizeY))
-	imagebounds := constructedImage.Bounds().Max
-	for x := 0; x < imagebounds.X; x++ {
-		for y := 0; y < imagebounds.Y; y++ {
-			constructedImage.Set(x, y, color.Black)
-		}
+	pipeline := opts.Pipeline
+	if opts.Mode == ModeOutline {
+		pipeline = pipeline.withOutline(maxInt(opts.StrokeWidth, 1))
 	}
+	// run the filter stages on the grayscale image, the pipeline always ends black and white
+	bwimage := pipeline.run(toLuminance(constructedImage), iconArea, opts.Threshold)
+	bwimage = opts.Transform.apply(bwimage)
 
-	for x := 0; x < resizedImage.Bounds().Max.X; x++ {
-		for y := 0; y < resizedImage.Bounds().Max.Y; y++ {
-			constructedImage.Set(x+amountToCenter, y, resizedImage.At(x, y))
-		}
+	bytedIMG := ssd1306FilePrep.ToBWByteSlice(bwimage, 128)
+	return bytedIMG, nil
+}
+
+// ConvertColorImage returns the contents of a .c file with the converted image for a colour display
+// The pipeline and mode are only used for black and white displays, everything else in opts is applied
+func ConvertColorImage(srcimg image.Image, opts ConvertOptions) ([]byte, error) {
+	if srcimg == nil {
+		return nil, errors.New("srcimg equal to nil")
+	}
+	profile := opts.Profile
+	if !profile.Controller.Color() {
+		return nil, fmt.Errorf("%s is not a colour display", profile.Controller)
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, err
 	}
 
-	bwimage := ssd1306FilePrep.ConvertBW(constructedImage, uint8(threshold))
+	canvasX, canvasY := profile.Width, profile.Height
+	if opts.Transform.swapsAxes() {
+		canvasX, canvasY = canvasY, canvasX
+	}
+	constructedImage, _ := layoutImage(srcimg, opts, canvasX, canvasY)
+	colorImage := opts.Transform.applyRGBA(constructedImage)
 
-	bytedIMG := ssd1306FilePrep.ToBWByteSlice(bwimage, uint8(threshold))
-	return bytedIMG, nil
+	if profile.Palette > 0 {
+		return cimage.MarshalPalette(colorImage, profile.Palette)
+	}
+	return cimage.Marshal(colorImage)
 }
 
 // CreateFileName creates a truncated 8 character filename using sha1 and the .b ending
 func CreateFileName(processname string) string {
+	return hashedFileName(processname) + ".B"
+}
+
+// CreateCompressedFileName creates a filename like CreateVariantFileName with the .br ending used by compressed images
+func CreateCompressedFileName(processname string, variant string) string {
+	return strings.TrimSuffix(CreateVariantFileName(processname, variant), ".B") + ".BR"
+}
+
+// CreateColorFileName creates a filename like CreateVariantFileName with the .c ending used by colour displays
+func CreateColorFileName(processname string, variant string) string {
+	if variant != "" {
+		processname += "#" + variant
+	}
+	return hashedFileName(processname) + ".C"
+}
+
+// hashedFileName returns the first 7 characters of the sha1 of name
+func hashedFileName(name string) string {
 	h := sha1.New()
-	h.Write([]byte(processname))
+	h.Write([]byte(name))
 	namehashed := h.Sum(nil)
-	name := fmt.Sprintf("%x", namehashed)
-	return name[0:7] + ".B"
+	return fmt.Sprintf("%x", namehashed)[0:7]
+}
+
+// CreateVariantFileName creates a filename like CreateFileName for a differently generated version of an image
+// An empty variant returns the same name as CreateFileName
+func CreateVariantFileName(processname string, variant string) string {
+	if variant == "" {
+		return CreateFileName(processname)
+	}
+	return CreateFileName(processname + "#" + variant)
+}
+
+// CreateAnimationFileName creates the name an animation is saved as on the sd card
+// The plain name is cut to 8 characters for the sd card library, variants for displays with their own settings are hashed like CreateVariantFileName
+func CreateAnimationFileName(name string, variant string) string {
+	if variant != "" {
+		return hashedFileName(strings.ToLower(name)+"#"+variant) + ".BA"
+	}
+	if len(name) > 8 {
+		name = name[:8]
+	}
+	return strings.ToUpper(name) + ".BA"
 }
 
 // CreateAutoMap creates a automatic mapping of sessions to the displays
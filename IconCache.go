@@ -0,0 +1,299 @@
+package deejdsp
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for the icon cache
+const (
+	DefaultIconCacheTTL        = 30 * 24 * time.Hour
+	DefaultIconCacheMissTTL    = 24 * time.Hour
+	DefaultIconCacheMaxEntries = 500
+)
+
+// iconCacheMetaExtension is the extension of the file holding the metadata of a cache entry
+const iconCacheMetaExtension = ".json"
+
+// IconCache keeps icons found by online providers on disk so they are not looked up again
+// Each entry is the original image file and a json file with where it came from and when.
+// Lookups that found nothing are kept too, for MissTTL, so a missing icon does not use up the api quota.
+type IconCache struct {
+	Path       string
+	TTL        time.Duration
+	MissTTL    time.Duration
+	MaxEntries int
+
+	lock sync.Mutex
+}
+
+// iconCacheEntry is the metadata stored next to a cached image
+type iconCacheEntry struct {
+	Provider string    `json:"provider"`
+	Keyword  string    `json:"keyword"`
+	Source   string    `json:"source,omitempty"`
+	File     string    `json:"file,omitempty"`
+	Fetched  time.Time `json:"fetched"`
+	Missing  bool      `json:"missing,omitempty"`
+}
+
+// cachedIconProvider puts an IconCache in front of another provider
+type cachedIconProvider struct {
+	provider IconProvider
+	cache    *IconCache
+}
+
+// remoteIconProvider is implemented by providers that look icons up over the network
+// Only these are cached by IconCache.WrapRemote, local files are quicker to read again than the cache
+type remoteIconProvider interface {
+	remote() bool
+}
+
+// NewIconCache creates a cache in path using the default limits
+// An empty path uses a folder in the cache directory of the user
+func NewIconCache(path string) (*IconCache, error) {
+	if path == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(base, "deejdsp", "icons")
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	return &IconCache{
+		Path:       path,
+		TTL:        DefaultIconCacheTTL,
+		MissTTL:    DefaultIconCacheMissTTL,
+		MaxEntries: DefaultIconCacheMaxEntries,
+	}, nil
+}
+
+// Wrap returns a provider that checks the cache before asking provider
+func (cache *IconCache) Wrap(provider IconProvider) IconProvider {
+	return &cachedIconProvider{provider: provider, cache: cache}
+}
+
+// WrapRemote wraps every provider in the chain that looks icons up over the network
+func (cache *IconCache) WrapRemote(chain IconProviderChain) IconProviderChain {
+	wrapped := make(IconProviderChain, len(chain))
+	for index, provider := range chain {
+		if remote, ok := provider.(remoteIconProvider); ok && remote.remote() {
+			provider = cache.Wrap(provider)
+		}
+		wrapped[index] = provider
+	}
+	return wrapped
+}
+
+// Name returns the name of the cached provider
+func (cached *cachedIconProvider) Name() string {
+	return cached.provider.Name()
+}
+
+// Lookup returns the cached icon if it is still fresh, otherwise it asks the provider and caches the answer
+// A stale icon is still used if the provider fails, a missing network is no reason to lose an icon
+func (cached *cachedIconProvider) Lookup(process string, profile DisplayProfile) (*Icon, error) {
+	icon, fresh, err := cached.cache.Get(cached.provider.Name(), process)
+	if fresh {
+		return icon, err
+	}
+
+	found, lookupErr := cached.provider.Lookup(process, profile)
+	switch {
+	case lookupErr == nil:
+		cached.cache.Put(cached.provider.Name(), process, found)
+		return found, nil
+	case errors.Is(lookupErr, ErrIconNotFound):
+		cached.cache.PutMissing(cached.provider.Name(), process)
+	case icon != nil:
+		return icon, nil
+	}
+	return nil, lookupErr
+}
+
+// key returns the file name used for a provider and keyword
+func (cache *IconCache) key(provider, keyword string) string {
+	sum := sha1.Sum([]byte(strings.ToLower(provider) + "\x00" + processKey(keyword)))
+	return fmt.Sprintf("%x", sum)
+}
+
+// Get returns the cached icon for a provider and keyword
+// fresh is false if there is no entry or it is older than the ttl, a stale icon is still returned
+// Fresh entries for icons that could not be found return ErrIconNotFound
+func (cache *IconCache) Get(provider, keyword string) (icon *Icon, fresh bool, err error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	entry, err := cache.readEntry(cache.key(provider, keyword))
+	if err != nil {
+		return nil, false, nil
+	}
+	age := time.Since(entry.Fetched)
+	if entry.Missing {
+		if age < cache.MissTTL {
+			return nil, true, fmt.Errorf("%w: %s found nothing for %s %s ago", ErrIconNotFound, provider, keyword, age.Round(time.Minute))
+		}
+		return nil, false, nil
+	}
+	img, err := decodeIconFile(filepath.Join(cache.Path, entry.File))
+	if err != nil {
+		return nil, false, nil
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(cache.Path, entry.File))
+	icon = &Icon{Image: img, Provider: entry.Provider, Source: entry.Source, Data: data}
+	return icon, age < cache.TTL, nil
+}
+
+// Put stores an icon in the cache
+// The original file is kept if the provider has it, otherwise the image is saved as a png
+func (cache *IconCache) Put(provider, keyword string, icon *Icon) error {
+	if icon == nil || icon.Image == nil {
+		return errors.New("no icon to cache")
+	}
+	data, extension := icon.Data, iconFileExtension(icon.Data)
+	if extension == "" {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, icon.Image); err != nil {
+			return err
+		}
+		data, extension = buf.Bytes(), ".png"
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	key := cache.key(provider, keyword)
+	cache.removeEntry(key)
+	if err := ioutil.WriteFile(filepath.Join(cache.Path, key+extension), data, 0644); err != nil {
+		return err
+	}
+	return cache.writeEntry(key, iconCacheEntry{
+		Provider: provider,
+		Keyword:  keyword,
+		Source:   icon.Source,
+		File:     key + extension,
+		Fetched:  time.Now(),
+	})
+}
+
+// PutMissing remembers that a provider has no icon for keyword
+func (cache *IconCache) PutMissing(provider, keyword string) error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	key := cache.key(provider, keyword)
+	cache.removeEntry(key)
+	return cache.writeEntry(key, iconCacheEntry{Provider: provider, Keyword: keyword, Fetched: time.Now(), Missing: true})
+}
+
+// Prune deletes expired entries and then the oldest entries until there are at most MaxEntries
+// Entries are kept for twice their ttl so they can still be used when the provider can not be reached
+func (cache *IconCache) Prune() error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(cache.Path, "*"+iconCacheMetaExtension))
+	if err != nil {
+		return err
+	}
+	type aged struct {
+		key     string
+		fetched time.Time
+	}
+	var kept []aged
+	for _, filename := range files {
+		key := strings.TrimSuffix(filepath.Base(filename), iconCacheMetaExtension)
+		entry, err := cache.readEntry(key)
+		if err != nil {
+			os.Remove(filename)
+			continue
+		}
+		ttl := cache.TTL
+		if entry.Missing {
+			ttl = cache.MissTTL
+		}
+		if time.Since(entry.Fetched) > 2*ttl {
+			cache.removeEntry(key)
+			continue
+		}
+		kept = append(kept, aged{key: key, fetched: entry.Fetched})
+	}
+
+	if cache.MaxEntries > 0 && len(kept) > cache.MaxEntries {
+		sort.Slice(kept, func(i, j int) bool { return kept[i].fetched.Before(kept[j].fetched) })
+		for _, entry := range kept[:len(kept)-cache.MaxEntries] {
+			cache.removeEntry(entry.key)
+		}
+	}
+	return nil
+}
+
+// Clear deletes every entry in the cache
+func (cache *IconCache) Clear() error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	files, err := filepath.Glob(filepath.Join(cache.Path, "*"+iconCacheMetaExtension))
+	if err != nil {
+		return err
+	}
+	for _, filename := range files {
+		cache.removeEntry(strings.TrimSuffix(filepath.Base(filename), iconCacheMetaExtension))
+	}
+	return nil
+}
+
+// readEntry reads the metadata of an entry, the lock has to be held
+func (cache *IconCache) readEntry(key string) (iconCacheEntry, error) {
+	var entry iconCacheEntry
+	data, err := ioutil.ReadFile(filepath.Join(cache.Path, key+iconCacheMetaExtension))
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(data, &entry)
+	return entry, err
+}
+
+// writeEntry writes the metadata of an entry, the lock has to be held
+func (cache *IconCache) writeEntry(key string, entry iconCacheEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(cache.Path, key+iconCacheMetaExtension), data, 0644)
+}
+
+// removeEntry deletes an entry and its image, the lock has to be held
+func (cache *IconCache) removeEntry(key string) {
+	if entry, err := cache.readEntry(key); err == nil && entry.File != "" {
+		os.Remove(filepath.Join(cache.Path, entry.File))
+	}
+	os.Remove(filepath.Join(cache.Path, key+iconCacheMetaExtension))
+}
+
+// iconFileExtension works out the extension of an image file from its contents
+// It returns an empty string for anything decodeIconFile can not read back
+func iconFileExtension(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(data, []byte(pngSignature)):
+		return ".png"
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return ".gif"
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return ".jpg"
+	case bytes.HasPrefix(trimmed, []byte("<svg")) || bytes.HasPrefix(trimmed, []byte("<?xml")):
+		return ".svg"
+	}
+	return ""
+}
@@ -0,0 +1,205 @@
+package deejdsp
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrIconNotFound is returned by a provider that has nothing for a process
+// The chain moves on to the next provider when it sees this error
+var ErrIconNotFound = errors.New("no icon found")
+
+// ErrIconProviderDisabled is returned when a provider can not be used with the settings it was given
+// Like iconfinder without an api key
+var ErrIconProviderDisabled = errors.New("icon provider disabled")
+
+// ErrIconProviderSilenced is a ErrIconProviderDisabled that the user asked not to be told about
+var ErrIconProviderSilenced = fmt.Errorf("%w: silenced", ErrIconProviderDisabled)
+
+// Icon is an image found by an IconProvider along with where it came from
+type Icon struct {
+	Image image.Image
+	// Provider is the name of the provider that found the icon
+	Provider string
+	// Source is where the provider got the image from, like a file path or a url
+	Source string
+	// Data is the file the image was decoded from, if the provider kept it
+	Data []byte
+}
+
+// IconProvider looks up an icon for an audio session
+// process is the session name from the slider mapping, like spotify.exe or firefox
+// profile is the display the icon is for so providers can pick the best size
+type IconProvider interface {
+	Name() string
+	Lookup(process string, profile DisplayProfile) (*Icon, error)
+}
+
+// IconProviderChain is an ordered list of providers, the first one that finds an icon wins
+type IconProviderChain []IconProvider
+
+// iconProviderFactory builds a provider from the settings given in the config, settings is never nil
+type iconProviderFactory func(settings map[string]interface{}) (IconProvider, error)
+
+var iconProviderFactories = map[string]iconProviderFactory{
+	"directory":   newDirectoryProvider,
+	"exe":         newExeProvider,
+	"freedesktop": newFreedesktopProvider,
+	"iconfinder":  newIconfinderProvider,
+}
+
+// IconProviderNames returns the names that can be used in the icon_providers config
+func IconProviderNames() []string {
+	names := make([]string, 0, len(iconProviderFactories))
+	for name := range iconProviderFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewIconProvider creates a provider by its config name
+func NewIconProvider(name string, settings map[string]interface{}) (IconProvider, error) {
+	factory, ok := iconProviderFactories[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown icon provider %q, need one of %v", name, IconProviderNames())
+	}
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	provider, err := factory(settings)
+	if err != nil {
+		return nil, fmt.Errorf("icon provider %q: %w", name, err)
+	}
+	return provider, nil
+}
+
+// ParseIconProviders builds a chain from the yaml list it was configured with
+// defaults holds settings per provider name that are used when the entry does not set them
+// Providers that are disabled by their settings are left out and their errors are returned in skipped
+// Each entry is either a provider name or a map of a provider name to a map of its settings
+//   - iconfinder
+//   - iconfinder: {api_key: XXXX}
+func ParseIconProviders(spec []interface{}, defaults map[string]map[string]interface{}) (chain IconProviderChain, skipped []error, err error) {
+	chain = IconProviderChain{}
+	add := func(name string, settings map[string]interface{}) error {
+		merged := map[string]interface{}{}
+		for key, value := range defaults[strings.ToLower(name)] {
+			merged[key] = value
+		}
+		for key, value := range settings {
+			merged[strings.ToLower(key)] = value
+		}
+		provider, err := NewIconProvider(name, merged)
+		if errors.Is(err, ErrIconProviderDisabled) {
+			skipped = append(skipped, err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		chain = append(chain, provider)
+		return nil
+	}
+
+	for index, entry := range spec {
+		switch typedEntry := entry.(type) {
+		case string:
+			if err := add(typedEntry, nil); err != nil {
+				return nil, nil, err
+			}
+		case map[string]interface{}:
+			if len(typedEntry) != 1 {
+				return nil, nil, fmt.Errorf("icon provider entry %d: need exactly one provider per entry, got %d", index, len(typedEntry))
+			}
+			for name, value := range typedEntry {
+				var settings map[string]interface{}
+				switch typedValue := value.(type) {
+				case map[string]interface{}:
+					settings = typedValue
+				case nil:
+				default:
+					return nil, nil, fmt.Errorf("icon provider %q: got type %T, need a map of settings", name, value)
+				}
+				if err := add(name, settings); err != nil {
+					return nil, nil, err
+				}
+			}
+		default:
+			return nil, nil, fmt.Errorf("icon provider entry %d: got type %T, need string or map", index, entry)
+		}
+	}
+	return chain, skipped, nil
+}
+
+// Names returns the name of each provider in order
+func (chain IconProviderChain) Names() []string {
+	names := make([]string, len(chain))
+	for index, provider := range chain {
+		names[index] = provider.Name()
+	}
+	return names
+}
+
+// Lookup asks each provider in order for an icon
+// Providers that fail for any other reason than ErrIconNotFound do not stop the chain,
+// their errors are returned if no provider finds an icon
+func (chain IconProviderChain) Lookup(process string, profile DisplayProfile) (*Icon, error) {
+	var failures []string
+	for _, provider := range chain {
+		icon, err := provider.Lookup(process, profile)
+		if err == nil && icon != nil && icon.Image != nil {
+			if icon.Provider == "" {
+				icon.Provider = provider.Name()
+			}
+			return icon, nil
+		}
+		if err != nil && !errors.Is(err, ErrIconNotFound) {
+			failures = append(failures, fmt.Sprintf("%s: %s", provider.Name(), err))
+		}
+	}
+	if len(failures) > 0 {
+		return nil, fmt.Errorf("%w for %s (%s)", ErrIconNotFound, process, strings.Join(failures, "; "))
+	}
+	return nil, fmt.Errorf("%w for %s", ErrIconNotFound, process)
+}
+
+// iconSettingString reads a string setting, missing settings return fallback
+func iconSettingString(settings map[string]interface{}, key string, fallback string) (string, error) {
+	value, ok := settings[key]
+	if !ok || value == nil {
+		return fallback, nil
+	}
+	text, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: got type %T, need string", key, value)
+	}
+	return text, nil
+}
+
+// processBaseName strips the extension and any path from a session name, C:\Games\game.exe becomes game
+func processBaseName(process string) string {
+	if index := strings.LastIndexAny(process, `/\`); index >= 0 {
+		process = process[index+1:]
+	}
+	return strings.Split(process, ".")[0]
+}
+
+// decodeIconFile loads an icon file found by a provider, svgs are decoded as a VectorImage
+func decodeIconFile(filename string) (image.Image, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	// svgs do not always start the same way so they are found by their extension
+	if strings.EqualFold(filepath.Ext(filename), ".svg") {
+		return DecodeSVG(file)
+	}
+	return decodeImage(file)
+}
@@ -0,0 +1,117 @@
+package deejdsp
+
+import (
+	"fmt"
+	_ "image/jpeg" // hand made icons are often jpgs
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// directoryIconExtensions are the files a DirectoryProvider will load, in order of preference
+var directoryIconExtensions = []string{".png", ".svg", ".gif", ".jpg", ".jpeg"}
+
+// DirectoryProvider looks up icons in a local folder of images named after processes
+// spotify.exe and Spotify both match spotify.png, aliases can point a process at any other file in the folder
+type DirectoryProvider struct {
+	Path string
+	// Aliases maps a process name to the name of an image in Path
+	Aliases map[string]string
+}
+
+// NewDirectoryProvider creates a provider for the images in path
+func NewDirectoryProvider(path string, aliases map[string]string) *DirectoryProvider {
+	provider := &DirectoryProvider{Path: path, Aliases: make(map[string]string)}
+	for process, name := range aliases {
+		provider.Aliases[processKey(process)] = name
+	}
+	return provider
+}
+
+// newDirectoryProvider builds the provider from the config, path is required and aliases is a map of process to image name
+func newDirectoryProvider(settings map[string]interface{}) (IconProvider, error) {
+	path, err := iconSettingString(settings, "path", "")
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("path: need the folder the icons are in")
+	}
+	aliases := make(map[string]string)
+	switch typedValue := settings["aliases"].(type) {
+	case map[string]interface{}:
+		for process, value := range typedValue {
+			name, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("aliases: %s: got type %T, need string", process, value)
+			}
+			aliases[process] = name
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("aliases: got type %T, need a map of process names to image names", typedValue)
+	}
+	return NewDirectoryProvider(path, aliases), nil
+}
+
+// Name returns the config name of the provider
+func (provider *DirectoryProvider) Name() string {
+	return "directory"
+}
+
+// Lookup finds the image for process in the folder and decodes it
+// The folder is read on every lookup so icons can be added without restarting
+func (provider *DirectoryProvider) Lookup(process string, profile DisplayProfile) (*Icon, error) {
+	filename, err := provider.find(process)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decodeIconFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", filename, err)
+	}
+	return &Icon{Image: img, Provider: provider.Name(), Source: filename}, nil
+}
+
+// find returns the path of the image for process
+// an alias is tried before the process name, names are matched ignoring case
+func (provider *DirectoryProvider) find(process string) (string, error) {
+	entries, err := ioutil.ReadDir(provider.Path)
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+	if alias, ok := provider.Aliases[processKey(process)]; ok {
+		names = append(names, alias)
+	}
+	names = append(names, processBaseName(process))
+
+	for _, name := range names {
+		// an alias can name the file exactly, extension and all
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.EqualFold(entry.Name(), name) && isDirectoryIcon(entry.Name()) {
+				return filepath.Join(provider.Path, entry.Name()), nil
+			}
+		}
+		for _, extension := range directoryIconExtensions {
+			for _, entry := range entries {
+				if !entry.IsDir() && strings.EqualFold(entry.Name(), name+extension) {
+					return filepath.Join(provider.Path, entry.Name()), nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: no image for %s in %s", ErrIconNotFound, process, provider.Path)
+}
+
+// isDirectoryIcon reports if filename has one of the extensions the provider loads
+func isDirectoryIcon(filename string) bool {
+	extension := strings.ToLower(filepath.Ext(filename))
+	for _, known := range directoryIconExtensions {
+		if extension == known {
+			return true
+		}
+	}
+	return false
+}
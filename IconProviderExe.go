@@ -0,0 +1,158 @@
+package deejdsp
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultExeSearchDepth is how many folders deep search_paths are looked through
+const defaultExeSearchDepth = 3
+
+// ExeProvider uses the icon built into the executable of a process
+// The executable is found from an explicit path in Executables, the running process (windows only)
+// or by looking for it in SearchPaths
+type ExeProvider struct {
+	// Executables maps a process name to the path of its executable
+	Executables map[string]string
+	// SearchPaths are folders that are looked through for the executable, like C:\Program Files
+	SearchPaths []string
+	// SearchDepth is how many folders below each search path are looked in
+	SearchDepth int
+}
+
+// NewExeProvider creates a provider that only uses running processes and the given executables
+func NewExeProvider(executables map[string]string) *ExeProvider {
+	provider := &ExeProvider{Executables: make(map[string]string), SearchDepth: defaultExeSearchDepth}
+	for process, path := range executables {
+		provider.Executables[processKey(process)] = path
+	}
+	return provider
+}
+
+// newExeProvider builds the provider from the config
+// executables is a map of process to executable path, search_paths a list of folders and search_depth how deep to look in them
+func newExeProvider(settings map[string]interface{}) (IconProvider, error) {
+	executables := make(map[string]string)
+	switch typedValue := settings["executables"].(type) {
+	case map[string]interface{}:
+		for process, value := range typedValue {
+			path, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("executables: %s: got type %T, need string", process, value)
+			}
+			executables[process] = path
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("executables: got type %T, need a map of process names to paths", typedValue)
+	}
+	provider := NewExeProvider(executables)
+
+	switch typedValue := settings["search_paths"].(type) {
+	case []interface{}:
+		for _, value := range typedValue {
+			path, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("search_paths: got type %T, need a list of folders", value)
+			}
+			provider.SearchPaths = append(provider.SearchPaths, path)
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("search_paths: got type %T, need a list of folders", typedValue)
+	}
+
+	if value, ok := settings["search_depth"]; ok && value != nil {
+		depth, ok := value.(int)
+		if !ok || depth < 0 {
+			return nil, fmt.Errorf("search_depth: need a whole number of 0 or more, got %v", value)
+		}
+		provider.SearchDepth = depth
+	}
+	return provider, nil
+}
+
+// Name returns the config name of the provider
+func (provider *ExeProvider) Name() string {
+	return "exe"
+}
+
+// Lookup finds the executable of process and decodes the biggest image of its icon
+func (provider *ExeProvider) Lookup(process string, profile DisplayProfile) (*Icon, error) {
+	filename, err := provider.FindExecutable(process)
+	if err != nil {
+		return nil, err
+	}
+	img, err := LargestExeIcon(filename)
+	if errors.Is(err, ErrNoExeIcon) {
+		return nil, fmt.Errorf("%w: %s has no icon", ErrIconNotFound, filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return &Icon{Image: img, Provider: provider.Name(), Source: filename}, nil
+}
+
+// FindExecutable returns the path of the executable for process
+func (provider *ExeProvider) FindExecutable(process string) (string, error) {
+	if filepath.IsAbs(process) {
+		if isFile(process) {
+			return process, nil
+		}
+	}
+	if path, ok := provider.Executables[processKey(process)]; ok {
+		if isFile(path) {
+			return path, nil
+		}
+		return "", fmt.Errorf("executable %s for %s does not exist", path, process)
+	}
+
+	name := filepath.Base(process)
+	if filepath.Ext(name) == "" {
+		name += ".exe"
+	}
+	if path, err := runningExecutable(name); err == nil {
+		return path, nil
+	}
+	for _, root := range provider.SearchPaths {
+		if path, ok := findFile(root, name, provider.SearchDepth); ok {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%w: could not find %s", ErrIconNotFound, name)
+}
+
+// findFile looks for a file called name in dir and the folders below it, ignoring case
+// Files in a folder are checked before going into its sub folders
+func findFile(dir string, name string, depth int) (string, bool) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(entry.Name(), name) {
+			return filepath.Join(dir, entry.Name()), true
+		}
+	}
+	if depth <= 0 {
+		return "", false
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if path, ok := findFile(filepath.Join(dir, entry.Name()), name, depth-1); ok {
+				return path, true
+			}
+		}
+	}
+	return "", false
+}
+
+// isFile reports if path exists and is not a folder
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package deejdsp
+
+import "errors"
+
+// runningExecutable is only supported on windows, everywhere else executables have to be found in search_paths
+func runningExecutable(name string) (string, error) {
+	return "", errors.New("finding running executables is not supported on this platform")
+}
@@ -0,0 +1,55 @@
+package deejdsp
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// processQueryLimitedInformation is enough access to read the path of any process
+// maxLongPath is the longest path windows will return
+const (
+	processQueryLimitedInformation = 0x1000
+	maxLongPath                    = 32768
+)
+
+var procQueryFullProcessImageName = syscall.NewLazyDLL("kernel32.dll").NewProc("QueryFullProcessImageNameW")
+
+// runningExecutable returns the path of the executable of a running process called name
+func runningExecutable(name string) (string, error) {
+	snapshot, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return "", err
+	}
+	defer syscall.CloseHandle(snapshot)
+
+	var entry syscall.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	for err = syscall.Process32First(snapshot, &entry); err == nil; err = syscall.Process32Next(snapshot, &entry) {
+		if !strings.EqualFold(syscall.UTF16ToString(entry.ExeFile[:]), name) {
+			continue
+		}
+		if path, err := processImageName(entry.ProcessID); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New(name + " is not running")
+}
+
+// processImageName returns the full path of the executable of a process
+func processImageName(pid uint32) (string, error) {
+	process, err := syscall.OpenProcess(processQueryLimitedInformation, false, pid)
+	if err != nil {
+		return "", err
+	}
+	defer syscall.CloseHandle(process)
+
+	buf := make([]uint16, maxLongPath)
+	size := uint32(len(buf))
+	ok, _, err := procQueryFullProcessImageName.Call(uintptr(process), 0, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)))
+	if ok == 0 {
+		return "", err
+	}
+	return syscall.UTF16ToString(buf[:size]), nil
+}
@@ -0,0 +1,428 @@
+package deejdsp
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultIconTheme is the theme every other theme falls back to
+const defaultIconTheme = "hicolor"
+
+// themeIconExtensions are the icon files looked for in a theme, in order of preference
+var themeIconExtensions = []string{".png", ".svg"}
+
+// FreedesktopProvider looks up icons the way linux desktops do
+// The process is matched to a .desktop file and its Icon= key is found in the icon theme
+// following the freedesktop icon theme spec. Processes without a .desktop file are looked up as an icon name.
+type FreedesktopProvider struct {
+	// Theme is the icon theme tried first, hicolor is always tried last
+	Theme string
+	// DataDirs are the XDG data directories holding applications/ and icons/, most important first
+	DataDirs []string
+	// IconDirs are the base directories themes are looked for in, most important first
+	IconDirs []string
+
+	themesLock sync.Mutex
+	themes     map[string]*iconTheme
+}
+
+// iconTheme is a parsed index.theme
+type iconTheme struct {
+	name     string
+	bases    []string
+	inherits []string
+	dirs     []iconThemeDir
+}
+
+// iconThemeDir is a directory entry in index.theme
+type iconThemeDir struct {
+	path                                string
+	size, scale, minSize, maxSize, slop int
+	kind                                string
+}
+
+// NewFreedesktopProvider creates a provider using the XDG directories of the current user
+// theme can be empty to only use hicolor
+func NewFreedesktopProvider(theme string) *FreedesktopProvider {
+	return NewFreedesktopProviderWithDirs(theme, xdgDataDirs())
+}
+
+// NewFreedesktopProviderWithDirs creates a provider that looks in dataDirs instead of the XDG directories
+func NewFreedesktopProviderWithDirs(theme string, dataDirs []string) *FreedesktopProvider {
+	provider := &FreedesktopProvider{Theme: theme, DataDirs: dataDirs, themes: make(map[string]*iconTheme)}
+	if home, err := os.UserHomeDir(); err == nil {
+		provider.IconDirs = append(provider.IconDirs, filepath.Join(home, ".icons"))
+	}
+	for _, dir := range dataDirs {
+		provider.IconDirs = append(provider.IconDirs, filepath.Join(dir, "icons"))
+	}
+	return provider
+}
+
+// newFreedesktopProvider builds the provider from the config
+// theme picks the icon theme and data_dirs replaces the XDG data directories
+func newFreedesktopProvider(settings map[string]interface{}) (IconProvider, error) {
+	theme, err := iconSettingString(settings, "theme", "")
+	if err != nil {
+		return nil, err
+	}
+	switch typedValue := settings["data_dirs"].(type) {
+	case []interface{}:
+		var dirs []string
+		for _, value := range typedValue {
+			dir, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("data_dirs: got type %T, need a list of folders", value)
+			}
+			dirs = append(dirs, dir)
+		}
+		return NewFreedesktopProviderWithDirs(theme, dirs), nil
+	case nil:
+		return NewFreedesktopProvider(theme), nil
+	default:
+		return nil, fmt.Errorf("data_dirs: got type %T, need a list of folders", typedValue)
+	}
+}
+
+// xdgDataDirs returns $XDG_DATA_HOME followed by $XDG_DATA_DIRS using the defaults from the spec
+func xdgDataDirs() []string {
+	var dirs []string
+	if home := os.Getenv("XDG_DATA_HOME"); home != "" {
+		dirs = append(dirs, home)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".local", "share"))
+	}
+	system := os.Getenv("XDG_DATA_DIRS")
+	if system == "" {
+		system = "/usr/local/share:/usr/share"
+	}
+	for _, dir := range strings.Split(system, ":") {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// Name returns the config name of the provider
+func (provider *FreedesktopProvider) Name() string {
+	return "freedesktop"
+}
+
+// Lookup finds the icon for process at the size of the display
+// Candidates are tried from the best size down so an icon that can not be decoded falls back to the next one
+func (provider *FreedesktopProvider) Lookup(process string, profile DisplayProfile) (*Icon, error) {
+	iconName := processBaseName(process)
+	if entry, err := provider.FindDesktopEntry(process); err == nil {
+		if icon := entry["Icon"]; icon != "" {
+			iconName = icon
+		}
+	}
+
+	size := profile.Width
+	if profile.Height > size {
+		size = profile.Height
+	}
+	candidates := provider.FindIconFiles(iconName, size)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: no %q icon in the %s theme", ErrIconNotFound, iconName, provider.themeName())
+	}
+	var failures []string
+	for _, filename := range candidates {
+		img, err := decodeIconFile(filename)
+		if err == nil {
+			return &Icon{Image: img, Provider: provider.Name(), Source: filename}, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", filename, err))
+	}
+	return nil, fmt.Errorf("%w: could not load %q (%s)", ErrIconNotFound, iconName, strings.Join(failures, "; "))
+}
+
+// FindDesktopEntry returns the [Desktop Entry] group of the .desktop file for process
+// Files are matched by their id (firefox.desktop or org.mozilla.firefox.desktop), StartupWMClass and the program in Exec= and TryExec=
+func (provider *FreedesktopProvider) FindDesktopEntry(process string) (map[string]string, error) {
+	name := processBaseName(process)
+	var byExec map[string]string
+	for _, dir := range provider.DataDirs {
+		files, _ := filepath.Glob(filepath.Join(dir, "applications", "*.desktop"))
+		for _, filename := range files {
+			id := strings.TrimSuffix(filepath.Base(filename), ".desktop")
+			groups, err := readDesktopFile(filename)
+			if err != nil {
+				continue
+			}
+			entry := groups["Desktop Entry"]
+			if entry == nil {
+				continue
+			}
+			lowerID := strings.ToLower(id)
+			lowerName := strings.ToLower(name)
+			if lowerID == lowerName || strings.HasSuffix(lowerID, "."+lowerName) || strings.EqualFold(entry["StartupWMClass"], name) {
+				return entry, nil
+			}
+			if byExec == nil && (strings.EqualFold(desktopExecProgram(entry["Exec"]), name) || strings.EqualFold(desktopExecProgram(entry["TryExec"]), name)) {
+				byExec = entry
+			}
+		}
+	}
+	if byExec != nil {
+		return byExec, nil
+	}
+	return nil, fmt.Errorf("%w: no .desktop file for %s", ErrIconNotFound, process)
+}
+
+// desktopExecProgram returns the name of the program run by an Exec= line without its path or arguments
+// env and its variables are skipped so "env FOO=1 /usr/bin/spotify %U" returns spotify
+func desktopExecProgram(exec string) string {
+	for _, field := range strings.Fields(exec) {
+		field = strings.Trim(field, `"`)
+		if field == "env" || strings.Contains(field, "=") {
+			continue
+		}
+		return processBaseName(field)
+	}
+	return ""
+}
+
+// FindIconFiles returns the files for an icon name from the best size for the display to the worst
+// Icon names can also be a path to a file, which is returned as is
+func (provider *FreedesktopProvider) FindIconFiles(iconName string, size int) []string {
+	if filepath.IsAbs(iconName) {
+		if _, err := os.Stat(iconName); err == nil {
+			return []string{iconName}
+		}
+		return nil
+	}
+	// old .desktop files sometimes name the file instead of the icon
+	for _, extension := range []string{".png", ".svg", ".xpm"} {
+		iconName = strings.TrimSuffix(iconName, extension)
+	}
+
+	var candidates []string
+	seen := make(map[string]bool)
+	for _, theme := range provider.themeChain() {
+		for _, dir := range theme.sortedDirs(size) {
+			for _, base := range theme.bases {
+				for _, extension := range themeIconExtensions {
+					filename := filepath.Join(base, dir.path, iconName+extension)
+					if seen[filename] {
+						continue
+					}
+					if _, err := os.Stat(filename); err == nil {
+						seen[filename] = true
+						candidates = append(candidates, filename)
+					}
+				}
+			}
+		}
+	}
+	// icons that are not part of any theme
+	for _, dir := range provider.DataDirs {
+		for _, extension := range themeIconExtensions {
+			filename := filepath.Join(dir, "pixmaps", iconName+extension)
+			if _, err := os.Stat(filename); err == nil && !seen[filename] {
+				seen[filename] = true
+				candidates = append(candidates, filename)
+			}
+		}
+	}
+	return candidates
+}
+
+// themeName returns the theme looked in first
+func (provider *FreedesktopProvider) themeName() string {
+	if provider.Theme == "" {
+		return defaultIconTheme
+	}
+	return provider.Theme
+}
+
+// themeChain returns the theme and every theme it inherits from, ending with hicolor
+func (provider *FreedesktopProvider) themeChain() []*iconTheme {
+	var chain []*iconTheme
+	visited := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] || name == defaultIconTheme {
+			return
+		}
+		visited[name] = true
+		theme := provider.loadTheme(name)
+		if theme == nil {
+			return
+		}
+		chain = append(chain, theme)
+		for _, parent := range theme.inherits {
+			visit(parent)
+		}
+	}
+	visit(provider.themeName())
+	if theme := provider.loadTheme(defaultIconTheme); theme != nil {
+		chain = append(chain, theme)
+	}
+	return chain
+}
+
+// loadTheme reads the index.theme of a theme, nil is returned if it is not installed
+// Themes are only read once per provider
+func (provider *FreedesktopProvider) loadTheme(name string) *iconTheme {
+	provider.themesLock.Lock()
+	defer provider.themesLock.Unlock()
+	if theme, ok := provider.themes[name]; ok {
+		return theme
+	}
+
+	// the same theme can be spread over several base directories, icons are looked up in all of them
+	// but only the first one with an index.theme describes the theme
+	var theme *iconTheme
+	var bases []string
+	for _, base := range provider.IconDirs {
+		dir := filepath.Join(base, name)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		bases = append(bases, dir)
+		if theme == nil {
+			if groups, err := readDesktopFile(filepath.Join(dir, "index.theme")); err == nil {
+				theme = parseIconTheme(name, groups)
+			}
+		}
+	}
+	if theme != nil {
+		theme.bases = bases
+	}
+	provider.themes[name] = theme
+	return theme
+}
+
+// parseIconTheme reads the directories and parents of a theme from its index.theme
+func parseIconTheme(name string, groups map[string]map[string]string) *iconTheme {
+	theme := &iconTheme{name: name}
+	header := groups["Icon Theme"]
+	for _, parent := range strings.Split(header["Inherits"], ",") {
+		if parent = strings.TrimSpace(parent); parent != "" {
+			theme.inherits = append(theme.inherits, parent)
+		}
+	}
+	for _, path := range strings.Split(header["Directories"], ",") {
+		path = strings.TrimSpace(path)
+		values, ok := groups[path]
+		if path == "" || !ok {
+			continue
+		}
+		dir := iconThemeDir{path: path, kind: "Threshold", scale: 1, slop: 2}
+		dir.size, _ = strconv.Atoi(values["Size"])
+		dir.minSize, dir.maxSize = dir.size, dir.size
+		if value, err := strconv.Atoi(values["Scale"]); err == nil {
+			dir.scale = value
+		}
+		if value, err := strconv.Atoi(values["MinSize"]); err == nil {
+			dir.minSize = value
+		}
+		if value, err := strconv.Atoi(values["MaxSize"]); err == nil {
+			dir.maxSize = value
+		}
+		if value, err := strconv.Atoi(values["Threshold"]); err == nil {
+			dir.slop = value
+		}
+		if kind := values["Type"]; kind != "" {
+			dir.kind = kind
+		}
+		// hidpi copies of the same icons are not needed
+		if dir.scale != 1 || dir.size <= 0 {
+			continue
+		}
+		theme.dirs = append(theme.dirs, dir)
+	}
+	return theme
+}
+
+// matches reports if icons in the directory are meant to be shown at size
+func (dir iconThemeDir) matches(size int) bool {
+	switch dir.kind {
+	case "Fixed":
+		return dir.size == size
+	case "Scalable":
+		return dir.minSize <= size && size <= dir.maxSize
+	default:
+		return dir.size-dir.slop <= size && size <= dir.size+dir.slop
+	}
+}
+
+// distance returns how far the icons in the directory are from size, and if they are bigger than it
+func (dir iconThemeDir) distance(size int) (int, bool) {
+	low, high := dir.size, dir.size
+	switch dir.kind {
+	case "Scalable":
+		low, high = dir.minSize, dir.maxSize
+	case "Fixed":
+	default:
+		low, high = dir.size-dir.slop, dir.size+dir.slop
+	}
+	switch {
+	case size < low:
+		return low - size, true
+	case size > high:
+		return size - high, false
+	}
+	return 0, true
+}
+
+// sortedDirs returns the directories of the theme from the best match for size to the worst
+// Unlike the spec, bigger icons come before smaller ones at any distance since they only lose detail when scaled down
+func (theme *iconTheme) sortedDirs(size int) []iconThemeDir {
+	dirs := make([]iconThemeDir, len(theme.dirs))
+	copy(dirs, theme.dirs)
+	sort.SliceStable(dirs, func(i, j int) bool {
+		matchI, matchJ := dirs[i].matches(size), dirs[j].matches(size)
+		if matchI != matchJ {
+			return matchI
+		}
+		distanceI, biggerI := dirs[i].distance(size)
+		distanceJ, biggerJ := dirs[j].distance(size)
+		if biggerI != biggerJ {
+			return biggerI
+		}
+		return distanceI < distanceJ
+	})
+	return dirs
+}
+
+// readDesktopFile parses a .desktop or index.theme file into its groups of keys
+// Localised keys like Name[de] are kept as they are
+func readDesktopFile(filename string) (map[string]map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	groups := make(map[string]map[string]string)
+	var group map[string]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			name := line[1 : len(line)-1]
+			if groups[name] == nil {
+				groups[name] = make(map[string]string)
+			}
+			group = groups[name]
+		default:
+			index := strings.Index(line, "=")
+			if index < 0 || group == nil {
+				continue
+			}
+			group[strings.TrimSpace(line[:index])] = strings.TrimSpace(line[index+1:])
+		}
+	}
+	return groups, scanner.Err()
+}
@@ -0,0 +1,500 @@
+package deejdsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jax-b/iconfinderapi"
+)
+
+// Defaults for the iconfinder provider
+const (
+	DefaultIconfinderURL       = "https://api.iconfinder.com/v4/"
+	DefaultIconfinderCount     = 10
+	DefaultIconfinderStyle     = "flat"
+	DefaultIconfinderDownloads = 3
+	DefaultIconfinderRetries   = 3
+	DefaultIconfinderInterval  = 250 * time.Millisecond
+)
+
+// iconfinderMaxCount is the most results the search api will return at once
+const iconfinderMaxCount = 100
+
+// iconfinderBackoff is how long to wait after the first rate limited request, it doubles for every retry
+const iconfinderBackoff = time.Second
+
+// iconfinderMaxBackoff caps the wait so a huge Retry-After does not freeze the displays
+const iconfinderMaxBackoff = time.Minute
+
+// IconfinderProvider looks up icons on iconfinder.com
+// Every search result is scored on how well it fits the display, the best few are downloaded
+// and scored again on how much contrast they keep once converted for the display.
+type IconfinderProvider struct {
+	APIKey  string
+	BaseURL string
+	Client  *http.Client
+
+	// Count is how many search results are scored
+	Count int
+	// Style and License filter the search, empty strings do not filter
+	Style   string
+	License string
+	// Premium allows icons that have to be bought, Vector allows svg icons
+	Premium bool
+	Vector  bool
+	// Downloads is how many of the best scoring results are downloaded to be scored on contrast
+	Downloads int
+	// Aliases maps a process name to the keywords searched for it, in order
+	Aliases map[string][]string
+
+	// MaxRetries is how many times a rate limited or failed request is tried again
+	MaxRetries int
+	// MinInterval is the shortest time between two requests
+	MinInterval time.Duration
+
+	requestLock sync.Mutex
+	lastRequest time.Time
+	sleep       func(time.Duration)
+}
+
+// iconfinderCandidate is a search result and the file that would be downloaded for it
+type iconfinderCandidate struct {
+	format iconfinderapi.Image
+	width  int
+	height int
+	score  float64
+}
+
+// errIconfinderRetry marks responses that are worth trying again
+var errIconfinderRetry = errors.New("iconfinder asked to try again later")
+
+// NewIconfinderProvider creates a provider using apiKey with the default search settings
+func NewIconfinderProvider(apiKey string) *IconfinderProvider {
+	return &IconfinderProvider{
+		APIKey:      apiKey,
+		BaseURL:     DefaultIconfinderURL,
+		Client:      &http.Client{Timeout: 30 * time.Second},
+		Count:       DefaultIconfinderCount,
+		Style:       DefaultIconfinderStyle,
+		Downloads:   DefaultIconfinderDownloads,
+		Aliases:     make(map[string][]string),
+		MaxRetries:  DefaultIconfinderRetries,
+		MinInterval: DefaultIconfinderInterval,
+		sleep:       time.Sleep,
+	}
+}
+
+// newIconfinderProvider builds the provider from the config
+// The api keys "example" and "silent" (or no key at all) disable the provider,
+// silent does it without a notification
+func newIconfinderProvider(settings map[string]interface{}) (IconProvider, error) {
+	key, err := iconSettingString(settings, "api_key", "")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.EqualFold(key, "silent"):
+		return nil, ErrIconProviderSilenced
+	case key == "" || strings.EqualFold(key, "example"):
+		return nil, fmt.Errorf("%w: iconfinder.com apikey not set, in order to use online icons please enter a icon finder api key", ErrIconProviderDisabled)
+	}
+	provider := NewIconfinderProvider(key)
+
+	if provider.BaseURL, err = iconSettingString(settings, "url", provider.BaseURL); err != nil {
+		return nil, err
+	}
+	if provider.Style, err = iconSettingString(settings, "style", provider.Style); err != nil {
+		return nil, err
+	}
+	if provider.License, err = iconSettingString(settings, "license", provider.License); err != nil {
+		return nil, err
+	}
+	for key, target := range map[string]*bool{"premium": &provider.Premium, "vector": &provider.Vector} {
+		if value, ok := settings[key]; ok && value != nil {
+			flag, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s: got type %T, need true or false", key, value)
+			}
+			*target = flag
+		}
+	}
+	for key, target := range map[string]*int{"count": &provider.Count, "downloads": &provider.Downloads, "retries": &provider.MaxRetries} {
+		if value, ok := settings[key]; ok && value != nil {
+			number, ok := value.(int)
+			if !ok || number < 0 {
+				return nil, fmt.Errorf("%s: need a whole number of 0 or more, got %v", key, value)
+			}
+			*target = number
+		}
+	}
+	if provider.Count < 1 || provider.Count > iconfinderMaxCount {
+		return nil, fmt.Errorf("count: has to be 1-%d, got %d", iconfinderMaxCount, provider.Count)
+	}
+	if value, ok := settings["min_interval"]; ok && value != nil {
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("min_interval: got type %T, need a duration like 500ms", value)
+		}
+		if provider.MinInterval, err = time.ParseDuration(text); err != nil {
+			return nil, fmt.Errorf("min_interval: %w", err)
+		}
+	}
+
+	switch typedValue := settings["aliases"].(type) {
+	case map[string]interface{}:
+		for process, value := range typedValue {
+			switch typedKeywords := value.(type) {
+			case string:
+				provider.Aliases[processKey(process)] = []string{typedKeywords}
+			case []interface{}:
+				for _, keyword := range typedKeywords {
+					text, ok := keyword.(string)
+					if !ok {
+						return nil, fmt.Errorf("aliases: %s: got type %T, need a list of keywords", process, keyword)
+					}
+					provider.Aliases[processKey(process)] = append(provider.Aliases[processKey(process)], text)
+				}
+			default:
+				return nil, fmt.Errorf("aliases: %s: got type %T, need a keyword or a list of keywords", process, value)
+			}
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("aliases: got type %T, need a map of process names to keywords", typedValue)
+	}
+	return provider, nil
+}
+
+// Name returns the config name of the provider
+func (provider *IconfinderProvider) Name() string {
+	return "iconfinder"
+}
+
+// remote marks the provider as one that is worth caching
+func (provider *IconfinderProvider) remote() bool {
+	return true
+}
+
+// Keywords returns the keywords searched for a process, its aliases or else its name
+func (provider *IconfinderProvider) Keywords(process string) []string {
+	if keywords, ok := provider.Aliases[processKey(process)]; ok && len(keywords) > 0 {
+		return keywords
+	}
+	return []string{processBaseName(process)}
+}
+
+// Lookup searches iconfinder for each keyword of the process until one has a usable icon
+func (provider *IconfinderProvider) Lookup(process string, profile DisplayProfile) (*Icon, error) {
+	for _, keyword := range provider.Keywords(process) {
+		icon, err := provider.lookupKeyword(keyword, profile)
+		if errors.Is(err, ErrIconNotFound) {
+			continue
+		}
+		return icon, err
+	}
+	return nil, fmt.Errorf("%w: no usable iconfinder results for %s", ErrIconNotFound, strings.Join(provider.Keywords(process), ", "))
+}
+
+// lookupKeyword searches for one keyword and returns the best scoring icon
+func (provider *IconfinderProvider) lookupKeyword(keyword string, profile DisplayProfile) (*Icon, error) {
+	search, err := provider.Search(keyword)
+	if err != nil {
+		return nil, err
+	}
+	candidates := provider.rankCandidates(search.Icons, profile)
+	if len(candidates) == 0 {
+		return nil, ErrIconNotFound
+	}
+
+	// download the best few and score them again on how they look once converted
+	downloads := minInt(maxInt(provider.Downloads, 1), len(candidates))
+	var best *Icon
+	bestScore := math.Inf(-1)
+	var lastErr error
+	for _, candidate := range candidates[:downloads] {
+		icon, err := provider.Download(candidate.format)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		score := candidate.score + conversionScore(icon.Image, profile)
+		if score > bestScore {
+			best, bestScore = icon, score
+		}
+	}
+	if best == nil {
+		return nil, lastErr
+	}
+	return best, nil
+}
+
+// Search runs the icon search api for keyword
+func (provider *IconfinderProvider) Search(keyword string) (*iconfinderapi.Icons, error) {
+	query := url.Values{}
+	query.Set("query", keyword)
+	query.Set("count", strconv.Itoa(provider.Count))
+	if !provider.Premium {
+		query.Set("premium", "0")
+	}
+	if !provider.Vector {
+		query.Set("vector", "0")
+	}
+	if provider.Style != "" {
+		query.Set("style", provider.Style)
+	}
+	if provider.License != "" {
+		query.Set("license", provider.License)
+	}
+
+	body, err := provider.get("icons/search?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	search := &iconfinderapi.Icons{}
+	if err := json.Unmarshal(body, search); err != nil {
+		return nil, fmt.Errorf("iconfinder search for %s: %w", keyword, err)
+	}
+	return search, nil
+}
+
+// Download fetches and decodes an icon file
+func (provider *IconfinderProvider) Download(format iconfinderapi.Image) (*Icon, error) {
+	body, err := provider.get(format.DownloadURL)
+	if err != nil {
+		return nil, err
+	}
+	// svgs are decoded as a VectorImage like decodeIconFile does so they are drawn at the size of the display
+	var img image.Image
+	if strings.EqualFold(format.Format, "svg") {
+		img, err = DecodeSVG(bytes.NewReader(body))
+	} else {
+		img, err = decodeImage(bytes.NewReader(body))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", format.DownloadURL, err)
+	}
+	return &Icon{Image: img, Provider: provider.Name(), Source: format.DownloadURL, Data: body}, nil
+}
+
+// rankCandidates picks the file that would be downloaded for every usable result and sorts them by score
+func (provider *IconfinderProvider) rankCandidates(icons []iconfinderapi.Icon, profile DisplayProfile) []iconfinderCandidate {
+	need := maxInt(profile.Width, profile.Height)
+	var candidates []iconfinderCandidate
+	for index, icon := range icons {
+		// the premium filter is checked again in case the api ignored it
+		if icon.IsPremium && !provider.Premium {
+			continue
+		}
+		candidate, ok := provider.pickFormat(icon, need)
+		if !ok {
+			continue
+		}
+		candidate.score = aspectScore(candidate.width, candidate.height, profile.Width, profile.Height) +
+			sizeScore(candidate.width, candidate.height, need)
+		// iconfinder puts the most relevant results first, break ties in its favour
+		candidate.score -= float64(index) * 0.01
+		candidates = append(candidates, candidate)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	return candidates
+}
+
+// pickFormat picks the smallest raster that fills the display, or the biggest one if none do
+// pngs are preferred, then svgs if vectors are allowed and no raster is big enough
+func (provider *IconfinderProvider) pickFormat(icon iconfinderapi.Icon, need int) (iconfinderCandidate, bool) {
+	var best iconfinderCandidate
+	found := false
+	for _, size := range icon.Rasters {
+		format, ok := preferredFormat(size.Formats, "png", "jpg", "jpeg", "gif")
+		if !ok {
+			continue
+		}
+		longest := maxInt(size.SizeWidth, size.SizeHeight)
+		bestLongest := maxInt(best.width, best.height)
+		better := !found ||
+			(longest >= need && (bestLongest < need || longest < bestLongest)) ||
+			(longest < need && bestLongest < need && longest > bestLongest)
+		if better {
+			best = iconfinderCandidate{format: format, width: size.SizeWidth, height: size.SizeHeight}
+			found = true
+		}
+	}
+	if provider.Vector && (!found || maxInt(best.width, best.height) < need) {
+		for _, size := range icon.Vectors {
+			if format, ok := preferredFormat(size.Formats, "svg"); ok {
+				// svgs are drawn at whatever size is needed so only their shape matters
+				width, height := size.SizeWidth, size.SizeHeight
+				if width <= 0 || height <= 0 {
+					width, height = need, need
+				}
+				scale := float64(need) / float64(maxInt(width, height))
+				return iconfinderCandidate{format: format, width: int(float64(width) * scale), height: int(float64(height) * scale)}, true
+			}
+		}
+	}
+	return best, found
+}
+
+// preferredFormat returns the first format in formats that matches one of names, in the order of names
+func preferredFormat(formats []iconfinderapi.Image, names ...string) (iconfinderapi.Image, bool) {
+	for _, name := range names {
+		for _, format := range formats {
+			if strings.EqualFold(format.Format, name) && format.DownloadURL != "" {
+				return format, true
+			}
+		}
+	}
+	return iconfinderapi.Image{}, false
+}
+
+// aspectScore is 1 when an icon has the same shape as the display and drops towards 0 the more it differs
+func aspectScore(width, height, displayWidth, displayHeight int) float64 {
+	if width <= 0 || height <= 0 || displayWidth <= 0 || displayHeight <= 0 {
+		return 0
+	}
+	icon := float64(width) / float64(height)
+	display := float64(displayWidth) / float64(displayHeight)
+	return math.Min(icon, display) / math.Max(icon, display)
+}
+
+// sizeScore is 1 for icons that fill the display without being scaled up and less for smaller ones
+func sizeScore(width, height, need int) float64 {
+	if need <= 0 {
+		return 1
+	}
+	return math.Min(float64(maxInt(width, height))/float64(need), 1)
+}
+
+// conversionScore rates how much of an icon survives being converted for the display, from 0 to 2
+// Icons that turn into a blank screen or a solid block score low, so do icons with little contrast
+func conversionScore(img image.Image, profile DisplayProfile) float64 {
+	if profile.Width <= 0 || profile.Height <= 0 {
+		profile = DefaultDisplayProfile()
+	}
+	opts := DefaultConvertOptions()
+	opts.Profile = profile
+	laidOut, area := layoutImage(img, opts, profile.Width, profile.Height)
+	if area.Empty() {
+		return 0
+	}
+	gray := toLuminance(laidOut.SubImage(area))
+	threshold := OtsuThreshold(gray)
+
+	var lit, dark int
+	var litSum, darkSum float64
+	for _, value := range gray.Pix {
+		if int(value) > threshold {
+			lit++
+			litSum += float64(value)
+		} else {
+			dark++
+			darkSum += float64(value)
+		}
+	}
+	if lit == 0 || dark == 0 {
+		return 0
+	}
+	// about a third of the icon lit up is right for a small screen
+	coverage := float64(lit) / float64(lit+dark)
+	coverageScore := math.Max(0, 1-math.Abs(coverage-0.35)/0.65)
+	contrast := (litSum/float64(lit) - darkSum/float64(dark)) / 255
+	return coverageScore + contrast
+}
+
+// get requests path from the api, path can be relative to BaseURL or a full url
+// Rate limited and failed requests are retried with a growing delay, using Retry-After when the api sends one
+func (provider *IconfinderProvider) get(path string) ([]byte, error) {
+	base, err := url.Parse(provider.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("iconfinder url: %w", err)
+	}
+	target, err := base.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := iconfinderBackoff
+	for attempt := 0; ; attempt++ {
+		provider.throttle()
+		body, wait, err := provider.request(target.String())
+		if !errors.Is(err, errIconfinderRetry) || attempt >= provider.MaxRetries {
+			return body, err
+		}
+		if wait <= 0 {
+			wait = backoff
+			backoff *= 2
+		}
+		if wait > iconfinderMaxBackoff {
+			wait = iconfinderMaxBackoff
+		}
+		provider.pause(wait)
+	}
+}
+
+// request makes a single request, wait is how long the api asked to wait before trying again
+func (provider *IconfinderProvider) request(target string) (body []byte, wait time.Duration, err error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+provider.APIKey)
+	client := provider.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %s", errIconfinderRetry, err)
+	}
+	defer resp.Body.Close()
+	// icons are small, anything this big is not an icon
+	body, err = ioutil.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %s", errIconfinderRetry, err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return body, 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			wait = time.Duration(seconds) * time.Second
+		}
+		return nil, wait, fmt.Errorf("%w: %s", errIconfinderRetry, resp.Status)
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, 0, fmt.Errorf("%w: %s returned %s", ErrIconNotFound, target, resp.Status)
+	}
+	return nil, 0, fmt.Errorf("iconfinder returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// pause sleeps for d, providers that were not made with NewIconfinderProvider use time.Sleep
+func (provider *IconfinderProvider) pause(d time.Duration) {
+	if provider.sleep == nil {
+		time.Sleep(d)
+		return
+	}
+	provider.sleep(d)
+}
+
+// throttle waits until MinInterval has passed since the last request
+func (provider *IconfinderProvider) throttle() {
+	provider.requestLock.Lock()
+	defer provider.requestLock.Unlock()
+	if wait := provider.MinInterval - time.Since(provider.lastRequest); wait > 0 && !provider.lastRequest.IsZero() {
+		provider.pause(wait)
+	}
+	provider.lastRequest = time.Now()
+}
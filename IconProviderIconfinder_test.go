@@ -0,0 +1,288 @@
+package deejdsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jax-b/iconfinderapi"
+)
+
+// testIconfinder is an iconfinder api that serves canned search results and icon files
+type testIconfinder struct {
+	t       *testing.T
+	server  *httptest.Server
+	results map[string][]iconfinderapi.Icon
+	// responses are sent for requests in order before the normal answers, a status of 0 is the normal answer
+	responses []testResponse
+
+	lock      sync.Mutex
+	queries   []string
+	downloads []string
+	sleeps    []time.Duration
+}
+
+// testResponse is a canned error response
+type testResponse struct {
+	status     int
+	retryAfter string
+}
+
+// newTestIconfinder starts a fake api and returns a provider that uses it without waiting between requests
+func newTestIconfinder(t *testing.T) (*testIconfinder, *IconfinderProvider) {
+	api := &testIconfinder{t: t, results: make(map[string][]iconfinderapi.Icon)}
+	api.server = httptest.NewServer(http.HandlerFunc(api.serve))
+	t.Cleanup(api.server.Close)
+
+	provider := NewIconfinderProvider("test-key")
+	provider.BaseURL = api.server.URL + "/v4/"
+	provider.MinInterval = 0
+	provider.sleep = func(wait time.Duration) {
+		api.lock.Lock()
+		defer api.lock.Unlock()
+		api.sleeps = append(api.sleeps, wait)
+	}
+	return api, provider
+}
+
+func (api *testIconfinder) serve(w http.ResponseWriter, r *http.Request) {
+	if auth := r.Header.Get("Authorization"); auth != "Bearer test-key" {
+		api.t.Errorf("request sent with authorization %q", auth)
+	}
+	api.lock.Lock()
+	var response testResponse
+	if len(api.responses) > 0 {
+		response, api.responses = api.responses[0], api.responses[1:]
+	}
+	api.lock.Unlock()
+	if response.status != 0 {
+		if response.retryAfter != "" {
+			w.Header().Set("Retry-After", response.retryAfter)
+		}
+		w.WriteHeader(response.status)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/v4/icons/search":
+		query := r.URL.Query()
+		api.lock.Lock()
+		api.queries = append(api.queries, r.URL.RawQuery)
+		api.lock.Unlock()
+		json.NewEncoder(w).Encode(iconfinderapi.Icons{Icons: api.results[query.Get("query")]})
+	case strings.HasPrefix(r.URL.Path, "/files/"):
+		api.lock.Lock()
+		api.downloads = append(api.downloads, r.URL.Path)
+		api.lock.Unlock()
+		if strings.HasSuffix(r.URL.Path, ".svg") {
+			w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><rect x="2" y="2" width="6" height="6" fill="#fff"/></svg>`))
+			return
+		}
+		w.Write(testIconPNG(api.t))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// file returns the url of an icon file on the fake api
+func (api *testIconfinder) file(name string) string {
+	return api.server.URL + "/files/" + name
+}
+
+// rasterIcon returns a search result with a png for every size
+func (api *testIconfinder) rasterIcon(name string, premium bool, sizes ...[2]int) iconfinderapi.Icon {
+	icon := iconfinderapi.Icon{IsPremium: premium}
+	for _, size := range sizes {
+		icon.Rasters = append(icon.Rasters, iconfinderapi.Sizes{
+			SizeWidth:  size[0],
+			SizeHeight: size[1],
+			Formats:    []iconfinderapi.Image{{Format: "png", DownloadURL: api.file(name + ".png")}},
+		})
+	}
+	return icon
+}
+
+// testIconPNG returns a white square on black, it converts well for any display
+func testIconPNG(t *testing.T) []byte {
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := 16; y < 48; y++ {
+		for x := 16; x < 48; x++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestIconfinderRanking(t *testing.T) {
+	api, provider := newTestIconfinder(t)
+	icons := []iconfinderapi.Icon{
+		api.rasterIcon("small", false, [2]int{16, 16}),
+		api.rasterIcon("square", false, [2]int{64, 64}, [2]int{512, 512}, [2]int{256, 256}),
+		api.rasterIcon("wide", false, [2]int{128, 64}),
+	}
+
+	// the wide icon fills a 128x64 display exactly, the square one has to be shrunk and the small one blown up
+	candidates := provider.rankCandidates(icons, DefaultDisplayProfile())
+	var order []string
+	for _, candidate := range candidates {
+		order = append(order, strings.TrimPrefix(candidate.format.DownloadURL, api.server.URL+"/files/"))
+	}
+	if strings.Join(order, " ") != "wide.png square.png small.png" {
+		t.Errorf("ranked %v, want wide, square then small", order)
+	}
+	// the smallest size that still fills the display is picked
+	if len(candidates) == 3 && candidates[1].width != 256 {
+		t.Errorf("picked the %dpx square icon, want 256px", candidates[1].width)
+	}
+
+	api.results["wide"] = icons
+	provider.Downloads = 1
+	icon, err := provider.Lookup("wide.exe", DefaultDisplayProfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if icon.Source != api.file("wide.png") {
+		t.Errorf("got %s, want the wide icon", icon.Source)
+	}
+	if len(api.downloads) != 1 {
+		t.Errorf("downloaded %v, only the best result should be downloaded", api.downloads)
+	}
+}
+
+func TestIconfinderPremiumFilter(t *testing.T) {
+	api, provider := newTestIconfinder(t)
+	api.results["game"] = []iconfinderapi.Icon{
+		api.rasterIcon("premium", true, [2]int{128, 64}),
+		api.rasterIcon("free", false, [2]int{64, 64}),
+	}
+
+	// the api is asked to leave premium icons out and they are skipped if it sends them anyway
+	icon, err := provider.Lookup("game.exe", DefaultDisplayProfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if icon.Source != api.file("free.png") {
+		t.Errorf("got %s, want the free icon", icon.Source)
+	}
+	if len(api.queries) != 1 || !strings.Contains(api.queries[0], "premium=0") {
+		t.Errorf("searched with %v, want premium=0", api.queries)
+	}
+
+	provider.Premium = true
+	icon, err = provider.Lookup("game.exe", DefaultDisplayProfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if icon.Source != api.file("premium.png") {
+		t.Errorf("got %s, want the premium icon once premium icons are allowed", icon.Source)
+	}
+	if strings.Contains(api.queries[1], "premium=") {
+		t.Errorf("searched with %s, premium icons should not be filtered", api.queries[1])
+	}
+}
+
+func TestIconfinderAliasFallback(t *testing.T) {
+	api, provider := newTestIconfinder(t)
+	provider.Aliases["game"] = []string{"nothing", "controller"}
+	api.results["controller"] = []iconfinderapi.Icon{api.rasterIcon("controller", false, [2]int{128, 128})}
+
+	// the first alias has no results so the next one is searched
+	icon, err := provider.Lookup("Game.exe", DefaultDisplayProfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if icon.Source != api.file("controller.png") {
+		t.Errorf("got %s, want the icon found for the second alias", icon.Source)
+	}
+	if len(api.queries) != 2 || !strings.Contains(api.queries[0], "query=nothing") || !strings.Contains(api.queries[1], "query=controller") {
+		t.Errorf("searched %v, want nothing then controller", api.queries)
+	}
+
+	// without a usable result for any alias the icon is not found
+	delete(api.results, "controller")
+	if _, err := provider.Lookup("game.exe", DefaultDisplayProfile()); err == nil {
+		t.Error("expected an error when no alias has results")
+	}
+}
+
+func TestIconfinderRetryAfter(t *testing.T) {
+	api, provider := newTestIconfinder(t)
+	api.results["game"] = []iconfinderapi.Icon{api.rasterIcon("game", false, [2]int{128, 128})}
+	// Retry-After is used when it is sent, otherwise the wait starts at a second and doubles
+	api.responses = []testResponse{
+		{status: http.StatusTooManyRequests, retryAfter: "7"},
+		{status: http.StatusTooManyRequests},
+		{status: http.StatusServiceUnavailable},
+	}
+
+	if _, err := provider.Lookup("game.exe", DefaultDisplayProfile()); err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{7 * time.Second, time.Second, 2 * time.Second}
+	if len(api.sleeps) != len(want) {
+		t.Fatalf("waited %v, want %v", api.sleeps, want)
+	}
+	for index := range want {
+		if api.sleeps[index] != want[index] {
+			t.Fatalf("waited %v, want %v", api.sleeps, want)
+		}
+	}
+}
+
+func TestIconfinderRetryGivesUp(t *testing.T) {
+	api, provider := newTestIconfinder(t)
+	provider.MaxRetries = 2
+	// a huge Retry-After is capped
+	api.responses = []testResponse{
+		{status: http.StatusTooManyRequests, retryAfter: "3600"},
+		{status: http.StatusTooManyRequests},
+		{status: http.StatusTooManyRequests},
+		{status: http.StatusTooManyRequests},
+	}
+
+	if _, err := provider.Search("game"); err == nil {
+		t.Fatal("expected an error once the retries run out")
+	}
+	want := []time.Duration{iconfinderMaxBackoff, time.Second}
+	if len(api.sleeps) != len(want) || api.sleeps[0] != want[0] || api.sleeps[1] != want[1] {
+		t.Errorf("waited %v, want %v", api.sleeps, want)
+	}
+	if len(api.responses) != 1 {
+		t.Errorf("%d requests were left, want 1 after trying %d times", len(api.responses), provider.MaxRetries+1)
+	}
+}
+
+func TestIconfinderDownloadSVG(t *testing.T) {
+	api, provider := newTestIconfinder(t)
+	icon, err := provider.Download(iconfinderapi.Image{Format: "svg", DownloadURL: api.file("shape.svg")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := icon.Image.(*SVGImage); !ok {
+		t.Errorf("got %T, want svgs to be decoded as vector images", icon.Image)
+	}
+}
+
+func TestIconfinderStructLiteral(t *testing.T) {
+	api, _ := newTestIconfinder(t)
+	api.results["game"] = []iconfinderapi.Icon{api.rasterIcon("game", false, [2]int{128, 128})}
+	// a provider that was not made with NewIconfinderProvider has no sleep func and still has to wait between requests
+	provider := &IconfinderProvider{APIKey: "test-key", BaseURL: api.server.URL + "/v4/", Count: 1, MinInterval: time.Millisecond}
+	for i := 0; i < 2; i++ {
+		if _, err := provider.Search("game"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
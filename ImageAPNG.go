@@ -0,0 +1,209 @@
+package deejdsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+	"time"
+)
+
+// pngSignature starts every png and apng file
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// APNG dispose and blend operations from the fcTL chunk
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+	apngBlendSource       = 0
+)
+
+// pngChunk is a single chunk of a png file without its length and crc
+type pngChunk struct {
+	kind string
+	data []byte
+}
+
+// apngFrame is a frame control chunk and the image data that goes with it
+type apngFrame struct {
+	width, height   int
+	x, y            int
+	delay           time.Duration
+	dispose, blend  byte
+	data            [][]byte
+	includesDefault bool
+}
+
+// readPNGChunks splits a png file into its chunks
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, errors.New("not a png file")
+	}
+	var chunks []pngChunk
+	offset := len(pngSignature)
+	for offset+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		kind := string(data[offset+4 : offset+8])
+		end := offset + 8 + length + 4
+		if length < 0 || end > len(data) {
+			return nil, fmt.Errorf("png chunk %q is cut off", kind)
+		}
+		chunks = append(chunks, pngChunk{kind: kind, data: data[offset+8 : offset+8+length]})
+		offset = end
+		if kind == "IEND" {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+// writePNGChunk appends a chunk with its length and crc to buf
+func writePNGChunk(buf *bytes.Buffer, kind string, data []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], kind)
+	buf.Write(header[:])
+	buf.Write(data)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	buf.Write(sum[:])
+}
+
+// decodeAPNG decodes an animated png, plain pngs return a single frame
+// Each frame is turned back into a standalone png so image/png can decode it
+func decodeAPNG(data []byte) (*Animation, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].kind != "IHDR" || len(chunks[0].data) != 13 {
+		return nil, errors.New("png does not start with a header")
+	}
+	ihdr := chunks[0].data
+
+	animated := false
+	var shared []pngChunk
+	var frames []*apngFrame
+	var current *apngFrame
+	seenIDAT := false
+	for _, chunk := range chunks[1:] {
+		switch chunk.kind {
+		case "acTL":
+			animated = true
+		case "fcTL":
+			if len(chunk.data) != 26 {
+				return nil, errors.New("apng frame control chunk is the wrong size")
+			}
+			current = &apngFrame{
+				width:   int(binary.BigEndian.Uint32(chunk.data[4:8])),
+				height:  int(binary.BigEndian.Uint32(chunk.data[8:12])),
+				x:       int(binary.BigEndian.Uint32(chunk.data[12:16])),
+				y:       int(binary.BigEndian.Uint32(chunk.data[16:20])),
+				dispose: chunk.data[24],
+				blend:   chunk.data[25],
+			}
+			numerator := binary.BigEndian.Uint16(chunk.data[20:22])
+			denominator := binary.BigEndian.Uint16(chunk.data[22:24])
+			if denominator == 0 {
+				denominator = 100
+			}
+			current.delay = frameDelay(time.Duration(numerator) * time.Second / time.Duration(denominator))
+			// a frame control chunk before the image data means the default image is the first frame
+			current.includesDefault = !seenIDAT
+			frames = append(frames, current)
+		case "IDAT":
+			seenIDAT = true
+			if current != nil && current.includesDefault {
+				current.data = append(current.data, chunk.data)
+			}
+		case "fdAT":
+			if current == nil || len(chunk.data) < 4 {
+				return nil, errors.New("apng frame data without a frame control chunk")
+			}
+			// the first 4 bytes are the sequence number
+			current.data = append(current.data, chunk.data[4:])
+		case "IEND":
+		default:
+			if !seenIDAT {
+				// palettes, transparency and colour profiles are needed to decode every frame
+				shared = append(shared, chunk)
+			}
+		}
+	}
+
+	if !animated || len(frames) == 0 {
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return &Animation{Frames: []image.Image{img}, Delays: []time.Duration{DefaultFrameDelay}}, nil
+	}
+
+	bounds := image.Rect(0, 0, int(binary.BigEndian.Uint32(ihdr[0:4])), int(binary.BigEndian.Uint32(ihdr[4:8])))
+	canvas := image.NewRGBA(bounds)
+	anim := &Animation{}
+	for index, frame := range frames {
+		img, err := decodeAPNGFrame(ihdr, shared, frame)
+		if err != nil {
+			return nil, fmt.Errorf("apng frame %d: %w", index, err)
+		}
+		area := image.Rect(frame.x, frame.y, frame.x+frame.width, frame.y+frame.height)
+
+		var previous *image.RGBA
+		if frame.dispose == apngDisposePrevious {
+			previous = cloneRGBA(canvas)
+		}
+		op := draw.Over
+		if frame.blend == apngBlendSource {
+			op = draw.Src
+		}
+		draw.Draw(canvas, area, img, img.Bounds().Min, op)
+		anim.Frames = append(anim.Frames, cloneRGBA(canvas))
+		anim.Delays = append(anim.Delays, frame.delay)
+
+		switch frame.dispose {
+		case apngDisposeBackground:
+			draw.Draw(canvas, area, image.Transparent, image.ZP, draw.Src)
+		case apngDisposePrevious:
+			// the first frame has nothing to go back to so it is cleared instead
+			if index == 0 {
+				draw.Draw(canvas, area, image.Transparent, image.ZP, draw.Src)
+			} else {
+				canvas = previous
+			}
+		}
+	}
+	return anim, nil
+}
+
+// decodeAPNGFrame builds a png of a single frame and decodes it
+func decodeAPNGFrame(ihdr []byte, shared []pngChunk, frame *apngFrame) (image.Image, error) {
+	if len(frame.data) == 0 {
+		return nil, errors.New("frame has no image data")
+	}
+	header := make([]byte, len(ihdr))
+	copy(header, ihdr)
+	binary.BigEndian.PutUint32(header[0:4], uint32(frame.width))
+	binary.BigEndian.PutUint32(header[4:8], uint32(frame.height))
+
+	var buf bytes.Buffer
+	buf.WriteString(pngSignature)
+	writePNGChunk(&buf, "IHDR", header)
+	for _, chunk := range shared {
+		writePNGChunk(&buf, chunk.kind, chunk.data)
+	}
+	for _, data := range frame.data {
+		writePNGChunk(&buf, "IDAT", data)
+	}
+	writePNGChunk(&buf, "IEND", nil)
+	return png.Decode(&buf)
+}
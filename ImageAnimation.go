@@ -0,0 +1,159 @@
+package deejdsp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/jax-b/deejdsp/bimage"
+)
+
+// DefaultFrameDelay is used for frames that do not say how long they are shown for
+const DefaultFrameDelay = 100 * time.Millisecond
+
+// minFrameDelay is the shortest delay kept as is, browsers treat anything shorter as DefaultFrameDelay
+const minFrameDelay = 20 * time.Millisecond
+
+// Animation is a decoded GIF or APNG
+// Every frame has already been drawn onto the full canvas so it can be converted on its own
+type Animation struct {
+	Frames []image.Image
+	Delays []time.Duration
+}
+
+// DecodeAnimation reads a GIF, APNG or any other image.Decode format from r
+// Images that are not animated return an Animation with a single frame
+func DecodeAnimation(r io.Reader) (*Animation, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return decodeGIFAnimation(data)
+	case bytes.HasPrefix(data, []byte(pngSignature)):
+		return decodeAPNG(data)
+	}
+	img, err := decodeImage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return &Animation{Frames: []image.Image{img}, Delays: []time.Duration{DefaultFrameDelay}}, nil
+}
+
+// decodeGIFAnimation draws every frame of a GIF onto the canvas following its disposal method
+func decodeGIFAnimation(data []byte) (*Animation, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(g.Image) == 0 {
+		return nil, errors.New("gif has no frames")
+	}
+
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		bounds = g.Image[0].Bounds()
+	}
+	canvas := image.NewRGBA(bounds)
+	anim := &Animation{}
+	for index, frame := range g.Image {
+		var disposal byte
+		if index < len(g.Disposal) {
+			disposal = g.Disposal[index]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		anim.Frames = append(anim.Frames, cloneRGBA(canvas))
+		anim.Delays = append(anim.Delays, frameDelay(time.Duration(g.Delay[index])*10*time.Millisecond))
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.ZP, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return anim, nil
+}
+
+// ConvertAnimation converts every frame of an animation into a .b sequence for the display described by opts
+// The icon is trimmed and given a background using all of the frames so it does not move or flicker
+func ConvertAnimation(anim *Animation, opts ConvertOptions) (*bimage.Sequence, error) {
+	if anim == nil || len(anim.Frames) == 0 {
+		return nil, errors.New("animation has no frames")
+	}
+	if len(anim.Frames) > bimage.MaxFrames {
+		return nil, fmt.Errorf("animation has %d frames, the most that can be played is %d", len(anim.Frames), bimage.MaxFrames)
+	}
+
+	if opts.Trim != TrimOff {
+		content := image.Rectangle{}
+		for _, frame := range anim.Frames {
+			content = content.Union(ContentBounds(frame, opts.Trim, opts.TrimTolerance))
+		}
+		frames := make([]image.Image, len(anim.Frames))
+		for index, frame := range anim.Frames {
+			frames[index] = cropImage(frame, content.Intersect(frame.Bounds()))
+		}
+		anim = &Animation{Frames: frames, Delays: anim.Delays}
+		opts.Trim = TrimOff
+	}
+	if opts.Background == BackgroundAuto {
+		opts.Background = BackgroundBlack
+		if BackgroundAuto.backgroundColor(anim.Frames[0]) == color.White {
+			opts.Background = BackgroundWhite
+		}
+	}
+
+	profile := opts.Profile
+	if profile.Width == 0 && profile.Height == 0 {
+		profile = DefaultDisplayProfile()
+	}
+	seq := &bimage.Sequence{Geometry: profile.Geometry()}
+	for index, frame := range anim.Frames {
+		pages, err := ConvertImageWithOptions(frame, opts)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", index, err)
+		}
+		data, err := bimage.JoinPages(pages)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", index, err)
+		}
+		delay := DefaultFrameDelay
+		if index < len(anim.Delays) {
+			delay = anim.Delays[index]
+		}
+		if delay > bimage.MaxFrameDelay {
+			delay = bimage.MaxFrameDelay
+		}
+		seq.Frames = append(seq.Frames, bimage.Frame{Data: data, Delay: delay})
+	}
+	return seq, nil
+}
+
+// frameDelay replaces delays that are too short to be meant with DefaultFrameDelay
+func frameDelay(delay time.Duration) time.Duration {
+	if delay < minFrameDelay {
+		return DefaultFrameDelay
+	}
+	return delay
+}
+
+// cloneRGBA returns a copy of img
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := image.NewRGBA(img.Bounds())
+	copy(clone.Pix, img.Pix)
+	return clone
+}
@@ -0,0 +1,68 @@
+package deejdsp
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// Background selects what transparent parts of an icon are composited onto
+type Background int
+
+// Supported backgrounds
+const (
+	BackgroundBlack Background = iota
+	BackgroundWhite
+	// BackgroundAuto picks black or white depending on how bright the visible part of the icon is
+	BackgroundAuto
+)
+
+// ParseBackground converts a config string into a Background
+func ParseBackground(value string) (Background, error) {
+	switch strings.ToLower(value) {
+	case "black":
+		return BackgroundBlack, nil
+	case "white":
+		return BackgroundWhite, nil
+	case "auto":
+		return BackgroundAuto, nil
+	}
+	return BackgroundBlack, fmt.Errorf("unknown background %q", value)
+}
+
+// backgroundColor returns the colour the icon should be composited onto
+func (bg Background) backgroundColor(srcimg image.Image) color.Color {
+	switch bg {
+	case BackgroundWhite:
+		return color.White
+	case BackgroundAuto:
+		// a dark icon would disappear on black so give it a white background
+		if mean, ok := opaqueLuminance(srcimg); ok && mean < 128 {
+			return color.White
+		}
+	}
+	return color.Black
+}
+
+// opaqueLuminance returns the mean brightness of an image weighted by the alpha of each pixel
+// ok is false if the image is fully transparent
+func opaqueLuminance(img image.Image) (mean uint8, ok bool) {
+	bounds := img.Bounds()
+	var total, weight uint64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if pixel.A == 0 {
+				continue
+			}
+			opaque := color.NRGBA{R: pixel.R, G: pixel.G, B: pixel.B, A: 255}
+			total += uint64(luminance(opaque)) * uint64(pixel.A)
+			weight += uint64(pixel.A)
+		}
+	}
+	if weight == 0 {
+		return 0, false
+	}
+	return uint8(total / weight), true
+}
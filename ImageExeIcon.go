@@ -0,0 +1,384 @@
+package deejdsp
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// Resource types and directories used to find icons in a windows executable
+const (
+	peResourceDirectory = 2
+	rtIcon              = 3
+	rtGroupIcon         = 14
+	// a resource directory entry pointing at another directory has the high bit set
+	peSubdirectoryFlag = 0x80000000
+	// windows will not nest resources deeper than type, name and language
+	peResourceDepth = 3
+)
+
+// ErrNoExeIcon is returned for executables without any icons
+var ErrNoExeIcon = errors.New("executable has no icons")
+
+// ExeIcon is a single image of an icon group in a windows executable
+// Data is either a png or a DIB as stored in a .ico file
+type ExeIcon struct {
+	Width, Height int
+	BitCount      int
+	Data          []byte
+}
+
+// peResource is a resource found in the resource directory, id is 0 for named resources
+type peResource struct {
+	kind uint32
+	id   uint32
+	data []byte
+}
+
+// ExtractExeIcons returns the images of the main icon of an executable, the icon explorer shows for it
+// This is the first RT_GROUP_ICON, files without groups return every RT_ICON
+func ExtractExeIcons(filename string) ([]ExeIcon, error) {
+	file, err := pe.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return extractPEIcons(file)
+}
+
+// ReadExeIcons is like ExtractExeIcons but reads the executable from r
+func ReadExeIcons(r io.ReaderAt) ([]ExeIcon, error) {
+	file, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	return extractPEIcons(file)
+}
+
+// LargestExeIcon decodes the biggest image of the main icon of an executable
+// Images of the same size are told apart by their colour depth
+func LargestExeIcon(filename string) (image.Image, error) {
+	icons, err := ExtractExeIcons(filename)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for len(icons) > 0 {
+		best := 0
+		for index, icon := range icons {
+			if icon.Width*icon.Height > icons[best].Width*icons[best].Height ||
+				icon.Width*icon.Height == icons[best].Width*icons[best].Height && icon.BitCount > icons[best].BitCount {
+				best = index
+			}
+		}
+		img, err := icons[best].Decode()
+		if err == nil {
+			return img, nil
+		}
+		// try the next biggest if this one is broken
+		lastErr = err
+		icons = append(icons[:best], icons[best+1:]...)
+	}
+	return nil, lastErr
+}
+
+// Decode decodes the icon image
+func (icon ExeIcon) Decode() (image.Image, error) {
+	if bytes.HasPrefix(icon.Data, []byte(pngSignature)) {
+		return png.Decode(bytes.NewReader(icon.Data))
+	}
+	return decodeIconDIB(icon.Data)
+}
+
+// extractPEIcons reads the icons out of the resource section of file
+func extractPEIcons(file *pe.File) ([]ExeIcon, error) {
+	var directory pe.DataDirectory
+	switch header := file.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if header.NumberOfRvaAndSizes <= peResourceDirectory {
+			return nil, ErrNoExeIcon
+		}
+		directory = header.DataDirectory[peResourceDirectory]
+	case *pe.OptionalHeader64:
+		if header.NumberOfRvaAndSizes <= peResourceDirectory {
+			return nil, ErrNoExeIcon
+		}
+		directory = header.DataDirectory[peResourceDirectory]
+	default:
+		return nil, ErrNoExeIcon
+	}
+	if directory.VirtualAddress == 0 || directory.Size == 0 {
+		return nil, ErrNoExeIcon
+	}
+
+	var section *pe.Section
+	for _, candidate := range file.Sections {
+		if directory.VirtualAddress >= candidate.VirtualAddress && directory.VirtualAddress < candidate.VirtualAddress+candidate.VirtualSize {
+			section = candidate
+			break
+		}
+	}
+	if section == nil {
+		return nil, errors.New("resource directory is not in any section")
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+	resources, err := readPEResources(data, section.VirtualAddress, directory.VirtualAddress-section.VirtualAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	images := make(map[uint32][]byte)
+	var group []byte
+	for _, resource := range resources {
+		switch resource.kind {
+		case rtIcon:
+			if _, ok := images[resource.id]; !ok {
+				images[resource.id] = resource.data
+			}
+		case rtGroupIcon:
+			if group == nil {
+				group = resource.data
+			}
+		}
+	}
+	if len(images) == 0 {
+		return nil, ErrNoExeIcon
+	}
+
+	var icons []ExeIcon
+	if group != nil {
+		icons, err = readIconGroup(group, images)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(icons) == 0 {
+		// without a usable group every image is a candidate, the size comes from the image itself
+		for _, data := range images {
+			icon := ExeIcon{Data: data}
+			if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+				icon.Width, icon.Height, icon.BitCount = config.Width, config.Height, 32
+			} else if len(data) >= 16 {
+				icon.Width = int(int32(binary.LittleEndian.Uint32(data[4:8])))
+				icon.Height = int(int32(binary.LittleEndian.Uint32(data[8:12]))) / 2
+				icon.BitCount = int(binary.LittleEndian.Uint16(data[14:16]))
+			}
+			icons = append(icons, icon)
+		}
+	}
+	if len(icons) == 0 {
+		return nil, ErrNoExeIcon
+	}
+	return icons, nil
+}
+
+// readPEResources walks the resource directory tree and returns every resource in it
+// start is where the root directory is in the section data
+func readPEResources(data []byte, sectionRVA uint32, start uint32) ([]peResource, error) {
+	var resources []peResource
+	visited := make(map[uint32]bool)
+	var walk func(offset uint32, depth int, kind, id uint32) error
+	walk = func(offset uint32, depth int, kind, id uint32) error {
+		dir := start + offset
+		if depth > peResourceDepth || visited[dir] {
+			return nil
+		}
+		visited[dir] = true
+		if int(dir)+16 > len(data) {
+			return errors.New("resource directory is cut off")
+		}
+		count := int(binary.LittleEndian.Uint16(data[dir+12:])) + int(binary.LittleEndian.Uint16(data[dir+14:]))
+		for index := 0; index < count; index++ {
+			entry := int(dir) + 16 + index*8
+			if entry+8 > len(data) {
+				return errors.New("resource directory entry is cut off")
+			}
+			name := binary.LittleEndian.Uint32(data[entry:])
+			target := binary.LittleEndian.Uint32(data[entry+4:])
+
+			entryKind, entryID := kind, id
+			switch depth {
+			case 0:
+				// only icons are of any use so every other type is skipped without reading it
+				if name != rtIcon && name != rtGroupIcon {
+					continue
+				}
+				entryKind = name
+			case 1:
+				// named resources keep an id of 0
+				if name&peSubdirectoryFlag == 0 {
+					entryID = name
+				}
+			}
+
+			if target&peSubdirectoryFlag != 0 {
+				if err := walk(target&^peSubdirectoryFlag, depth+1, entryKind, entryID); err != nil {
+					return err
+				}
+				continue
+			}
+			leaf := int(start) + int(target)
+			if leaf+16 > len(data) {
+				return errors.New("resource data entry is cut off")
+			}
+			rva := binary.LittleEndian.Uint32(data[leaf:])
+			size := binary.LittleEndian.Uint32(data[leaf+4:])
+			if rva < sectionRVA || uint64(rva-sectionRVA)+uint64(size) > uint64(len(data)) {
+				return fmt.Errorf("resource %d of type %d points outside the resource section", entryID, entryKind)
+			}
+			resources = append(resources, peResource{kind: entryKind, id: entryID, data: data[rva-sectionRVA : rva-sectionRVA+size]})
+		}
+		return nil
+	}
+	if err := walk(0, 0, 0, 0); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+// readIconGroup reads a GRPICONDIR and matches its entries to the RT_ICON images
+func readIconGroup(group []byte, images map[uint32][]byte) ([]ExeIcon, error) {
+	if len(group) < 6 {
+		return nil, errors.New("icon group is cut off")
+	}
+	count := int(binary.LittleEndian.Uint16(group[4:6]))
+	var icons []ExeIcon
+	for index := 0; index < count; index++ {
+		entry := 6 + index*14
+		if entry+14 > len(group) {
+			return nil, errors.New("icon group entry is cut off")
+		}
+		data, ok := images[uint32(binary.LittleEndian.Uint16(group[entry+12:]))]
+		if !ok {
+			continue
+		}
+		// a size of 0 means 256
+		width, height := int(group[entry]), int(group[entry+1])
+		if width == 0 {
+			width = 256
+		}
+		if height == 0 {
+			height = 256
+		}
+		icons = append(icons, ExeIcon{
+			Width:    width,
+			Height:   height,
+			BitCount: int(binary.LittleEndian.Uint16(group[entry+6:])),
+			Data:     data,
+		})
+	}
+	return icons, nil
+}
+
+// decodeIconDIB decodes a bitmap as stored in an icon
+// The bitmap is stored bottom up with double its height, the colour image followed by a 1 bit transparency mask
+func decodeIconDIB(data []byte) (image.Image, error) {
+	if len(data) < 40 {
+		return nil, errors.New("icon bitmap header is cut off")
+	}
+	headerSize := int(binary.LittleEndian.Uint32(data[0:4]))
+	width := int(int32(binary.LittleEndian.Uint32(data[4:8])))
+	height := int(int32(binary.LittleEndian.Uint32(data[8:12]))) / 2
+	bitCount := int(binary.LittleEndian.Uint16(data[14:16]))
+	compression := binary.LittleEndian.Uint32(data[16:20])
+	colorsUsed := int(binary.LittleEndian.Uint32(data[32:36]))
+	if width <= 0 || height <= 0 || width > 1024 || height > 1024 {
+		return nil, fmt.Errorf("icon bitmap has a bad size of %dx%d", width, height)
+	}
+	// BI_RGB, or BI_BITFIELDS which icons only use for plain BGRA
+	if compression != 0 && compression != 3 {
+		return nil, fmt.Errorf("icon bitmap uses unsupported compression %d", compression)
+	}
+	offset := headerSize
+	if compression == 3 && headerSize == 40 {
+		offset += 12
+	}
+
+	var palette []color.RGBA
+	if bitCount <= 8 {
+		if colorsUsed == 0 || colorsUsed > 1<<uint(bitCount) {
+			colorsUsed = 1 << uint(bitCount)
+		}
+		if offset+colorsUsed*4 > len(data) {
+			return nil, errors.New("icon bitmap palette is cut off")
+		}
+		for index := 0; index < colorsUsed; index++ {
+			entry := data[offset+index*4:]
+			palette = append(palette, color.RGBA{R: entry[2], G: entry[1], B: entry[0], A: 0xff})
+		}
+		offset += colorsUsed * 4
+	}
+
+	switch bitCount {
+	case 1, 4, 8, 24, 32:
+	default:
+		return nil, fmt.Errorf("icon bitmap has an unsupported depth of %d bits", bitCount)
+	}
+	stride := (width*bitCount + 31) / 32 * 4
+	maskStride := (width + 31) / 32 * 4
+	if offset+stride*height > len(data) {
+		return nil, errors.New("icon bitmap is cut off")
+	}
+	pixels := data[offset : offset+stride*height]
+	var mask []byte
+	if maskStart := offset + stride*height; maskStart+maskStride*height <= len(data) {
+		mask = data[maskStart : maskStart+maskStride*height]
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	hasAlpha := false
+	for y := 0; y < height; y++ {
+		row := pixels[(height-1-y)*stride:]
+		for x := 0; x < width; x++ {
+			var c color.NRGBA
+			switch bitCount {
+			case 32:
+				c = color.NRGBA{R: row[x*4+2], G: row[x*4+1], B: row[x*4], A: row[x*4+3]}
+				if c.A != 0 {
+					hasAlpha = true
+				}
+			case 24:
+				c = color.NRGBA{R: row[x*3+2], G: row[x*3+1], B: row[x*3], A: 0xff}
+			default:
+				perByte := 8 / bitCount
+				shift := uint(8 - bitCount*(x%perByte+1))
+				index := int(row[x/perByte]>>shift) & (1<<uint(bitCount) - 1)
+				if index < len(palette) {
+					p := palette[index]
+					c = color.NRGBA{R: p.R, G: p.G, B: p.B, A: 0xff}
+				}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	// 32 bit icons carry their own alpha, everything else (and 32 bit icons with an empty alpha) use the mask
+	if hasAlpha || mask == nil {
+		if bitCount == 32 && !hasAlpha {
+			for index := 3; index < len(img.Pix); index += 4 {
+				img.Pix[index] = 0xff
+			}
+		}
+		return img, nil
+	}
+	for y := 0; y < height; y++ {
+		row := mask[(height-1-y)*maskStride:]
+		for x := 0; x < width; x++ {
+			alpha := uint8(0xff)
+			if row[x/8]&(0x80>>uint(x%8)) != 0 {
+				alpha = 0
+			}
+			img.Pix[y*img.Stride+x*4+3] = alpha
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,202 @@
+package deejdsp
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// the executables in testdata are made by testdata/exeicons.py
+
+// readTestExe reads the icons of an executable in testdata
+func readTestExe(t *testing.T, name string) ([]ExeIcon, error) {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	return ReadExeIcons(f)
+}
+
+// checkIconPicture checks img is the picture every test icon has:
+// red on the left, blue on the right and a transparent top left pixel
+func checkIconPicture(t *testing.T, img image.Image, size int) {
+	t.Helper()
+	if bounds := img.Bounds(); bounds.Dx() != size || bounds.Dy() != size {
+		t.Fatalf("icon is %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), size, size)
+	}
+	checks := []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{0, 0, color.NRGBA{A: 0}},
+		{1, 0, color.NRGBA{R: 255, A: 255}},
+		{0, size - 1, color.NRGBA{R: 255, A: 255}},
+		{size - 1, 0, color.NRGBA{B: 255, A: 255}},
+		{size - 1, size - 1, color.NRGBA{B: 255, A: 255}},
+	}
+	for _, check := range checks {
+		got := color.NRGBAModel.Convert(img.At(check.x, check.y)).(color.NRGBA)
+		if check.want.A == 0 {
+			if got.A != 0 {
+				t.Errorf("pixel %d,%d is %v, want transparent", check.x, check.y, got)
+			}
+			continue
+		}
+		if got != check.want {
+			t.Errorf("pixel %d,%d is %v, want %v", check.x, check.y, got, check.want)
+		}
+	}
+}
+
+// iconSizes returns the width and depth of every icon sorted
+func iconSizes(icons []ExeIcon) [][2]int {
+	var sizes [][2]int
+	for _, icon := range icons {
+		sizes = append(sizes, [2]int{icon.Width, icon.BitCount})
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		return sizes[i][0] < sizes[j][0] || sizes[i][0] == sizes[j][0] && sizes[i][1] < sizes[j][1]
+	})
+	return sizes
+}
+
+func TestReadExeIconsGroup(t *testing.T) {
+	icons, err := readTestExe(t, "group.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the 64x64 icon is not in the group so it is left out
+	want := [][2]int{{16, 8}, {32, 24}, {48, 32}}
+	got := iconSizes(icons)
+	if len(got) != len(want) {
+		t.Fatalf("got icons %v, want %v", got, want)
+	}
+	for index := range want {
+		if got[index] != want[index] {
+			t.Fatalf("got icons %v, want %v", got, want)
+		}
+	}
+}
+
+func TestReadExeIconsWithoutGroup(t *testing.T) {
+	icons, err := readTestExe(t, "icononly.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the sizes come from the bitmap headers
+	want := [][2]int{{16, 8}, {24, 24}, {32, 32}, {32, 32}}
+	got := iconSizes(icons)
+	if len(got) != len(want) {
+		t.Fatalf("got icons %v, want %v", got, want)
+	}
+	for index := range want {
+		if got[index] != want[index] {
+			t.Fatalf("got icons %v, want %v", got, want)
+		}
+	}
+	for _, icon := range icons {
+		if icon.Height != icon.Width {
+			t.Errorf("icon is %dx%d, the mask should not be counted in the height", icon.Width, icon.Height)
+		}
+	}
+}
+
+func TestReadExeIconsLoop(t *testing.T) {
+	icons, err := readTestExe(t, "loop.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(icons) != 1 || icons[0].Width != 16 {
+		t.Fatalf("got icons %v, want one 16x16 icon", iconSizes(icons))
+	}
+}
+
+func TestReadExeIconsTruncated(t *testing.T) {
+	_, err := readTestExe(t, "truncated.exe")
+	if err == nil || errors.Is(err, ErrNoExeIcon) {
+		t.Fatalf("got error %v, want the directory to be cut off", err)
+	}
+}
+
+func TestLargestExeIcon(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"group.exe", 48},
+		{"icononly.exe", 32},
+		{"loop.exe", 16},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img, err := LargestExeIcon(filepath.Join("testdata", test.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkIconPicture(t, img, test.size)
+		})
+	}
+
+	if _, err := LargestExeIcon(filepath.Join("testdata", "truncated.exe")); err == nil {
+		t.Error("truncated.exe should not have an icon")
+	}
+}
+
+func TestDecodeIconDIB(t *testing.T) {
+	icons, err := readTestExe(t, "icononly.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// covers the palette, 24 bit, 32 bit with alpha and 32 bit with only a mask
+	for _, icon := range icons {
+		img, err := decodeIconDIB(icon.Data)
+		if err != nil {
+			t.Fatalf("%dx%d %d bit: %s", icon.Width, icon.Height, icon.BitCount, err)
+		}
+		checkIconPicture(t, img, icon.Width)
+	}
+}
+
+func TestDecodeIconDIBErrors(t *testing.T) {
+	icons, err := readTestExe(t, "icononly.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	for _, icon := range icons {
+		if icon.BitCount == 24 {
+			data = icon.Data
+		}
+	}
+
+	badDepth := append([]byte(nil), data...)
+	badDepth[14] = 16
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"header", data[:20]},
+		{"pixels", data[:100]},
+		{"depth", badDepth},
+	}
+	for _, test := range tests {
+		if _, err := decodeIconDIB(test.data); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+
+	// without the mask the icon is opaque
+	img, err := decodeIconDIB(data[:len(data)-24*4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := color.NRGBAModel.Convert(img.At(0, 0)).(color.NRGBA); got.A != 255 {
+		t.Errorf("pixel 0,0 is %v, want opaque without a mask", got)
+	}
+}
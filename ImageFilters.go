@@ -0,0 +1,402 @@
+package deejdsp
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// thresholdFromOptions tells a threshold stage to use the threshold from ConvertOptions
+const thresholdFromOptions = 0
+
+// formatStageFloat formats a stage parameter for Key the same way on every platform
+func formatStageFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// thresholdStage turns the image black and white
+// level is a fixed value, ThresholdOtsu, ThresholdAdaptive or thresholdFromOptions
+type thresholdStage struct {
+	level int
+}
+
+func newThresholdStage(param interface{}) (FilterStage, error) {
+	switch typedParam := param.(type) {
+	case nil:
+		return &thresholdStage{level: thresholdFromOptions}, nil
+	case int:
+		if typedParam <= 0 || typedParam > 255 {
+			return nil, fmt.Errorf("threshold must be 1-255, got %d", typedParam)
+		}
+		return &thresholdStage{level: typedParam}, nil
+	case string:
+		switch strings.ToLower(typedParam) {
+		case "auto", "otsu":
+			return &thresholdStage{level: ThresholdOtsu}, nil
+		case "adaptive":
+			return &thresholdStage{level: ThresholdAdaptive}, nil
+		}
+	}
+	return nil, fmt.Errorf("need 1-255, auto or adaptive, got %v", param)
+}
+
+func (stage *thresholdStage) Name() string { return "threshold" }
+
+func (stage *thresholdStage) Key() string {
+	switch stage.level {
+	case thresholdFromOptions:
+		return "threshold"
+	case ThresholdOtsu:
+		return "threshold:auto"
+	case ThresholdAdaptive:
+		return "threshold:adaptive"
+	}
+	return "threshold:" + strconv.Itoa(stage.level)
+}
+
+func (stage *thresholdStage) Apply(img *image.Gray, area image.Rectangle) *image.Gray {
+	return stage.threshold(img, area, DefaultConvertOptions().Threshold)
+}
+
+// threshold converts the image using fallback when the stage was not given its own level
+func (stage *thresholdStage) threshold(img *image.Gray, area image.Rectangle, fallback int) *image.Gray {
+	level := stage.level
+	if level == thresholdFromOptions {
+		level = fallback
+	}
+	switch level {
+	case ThresholdOtsu:
+		// only look at the icon itself so the border does not skew the result
+		level = OtsuThreshold(img.SubImage(area))
+	case ThresholdAdaptive:
+		return AdaptiveThreshold(img)
+	}
+	return mapGray(img, func(value uint8) uint8 {
+		if int(value) >= level {
+			return 255
+		}
+		return 0
+	})
+}
+
+// brightnessStage adds a fixed amount to every pixel
+type brightnessStage struct {
+	amount int
+}
+
+func newBrightnessStage(param interface{}) (FilterStage, error) {
+	amount, err := intParam(param, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &brightnessStage{amount: amount}, nil
+}
+
+func (stage *brightnessStage) Name() string { return "brightness" }
+
+func (stage *brightnessStage) Key() string { return "brightness:" + strconv.Itoa(stage.amount) }
+
+func (stage *brightnessStage) Apply(img *image.Gray, area image.Rectangle) *image.Gray {
+	return mapGray(img, func(value uint8) uint8 {
+		return clampUint8(float64(int(value) + stage.amount))
+	})
+}
+
+// contrastStage scales every pixel away from or towards mid gray
+type contrastStage struct {
+	factor float64
+}
+
+func newContrastStage(param interface{}) (FilterStage, error) {
+	factor, err := floatParam(param, 1.5)
+	if err != nil {
+		return nil, err
+	}
+	if factor < 0 {
+		return nil, fmt.Errorf("contrast cannot be negative, got %v", factor)
+	}
+	return &contrastStage{factor: factor}, nil
+}
+
+func (stage *contrastStage) Name() string { return "contrast" }
+
+func (stage *contrastStage) Key() string { return "contrast:" + formatStageFloat(stage.factor) }
+
+func (stage *contrastStage) Apply(img *image.Gray, area image.Rectangle) *image.Gray {
+	return mapGray(img, func(value uint8) uint8 {
+		return clampUint8((float64(value)-128)*stage.factor + 128)
+	})
+}
+
+// gammaStage applies a gamma curve, values above 1 brighten the mid tones
+type gammaStage struct {
+	gamma float64
+}
+
+func newGammaStage(param interface{}) (FilterStage, error) {
+	gamma, err := floatParam(param, 1)
+	if err != nil {
+		return nil, err
+	}
+	if gamma <= 0 {
+		return nil, fmt.Errorf("gamma must be above 0, got %v", gamma)
+	}
+	return &gammaStage{gamma: gamma}, nil
+}
+
+func (stage *gammaStage) Name() string { return "gamma" }
+
+func (stage *gammaStage) Key() string { return "gamma:" + formatStageFloat(stage.gamma) }
+
+func (stage *gammaStage) Apply(img *image.Gray, area image.Rectangle) *image.Gray {
+	var table [256]uint8
+	for value := range table {
+		table[value] = clampUint8(255 * math.Pow(float64(value)/255, 1/stage.gamma))
+	}
+	return mapGray(img, func(value uint8) uint8 {
+		return table[value]
+	})
+}
+
+// sharpenStage is an unsharp mask using a 3x3 box blur
+type sharpenStage struct {
+	amount float64
+}
+
+func newSharpenStage(param interface{}) (FilterStage, error) {
+	amount, err := floatParam(param, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &sharpenStage{amount: amount}, nil
+}
+
+func (stage *sharpenStage) Name() string { return "sharpen" }
+
+func (stage *sharpenStage) Key() string { return "sharpen:" + formatStageFloat(stage.amount) }
+
+func (stage *sharpenStage) Apply(img *image.Gray, area image.Rectangle) *image.Gray {
+	blurred := neighbourhood(img, 1, func(values []uint8) uint8 {
+		sum := 0
+		for _, value := range values {
+			sum += int(value)
+		}
+		return uint8(sum / len(values))
+	})
+	dst := image.NewGray(img.Rect)
+	for index, value := range img.Pix {
+		dst.Pix[index] = clampUint8(float64(value) + stage.amount*(float64(value)-float64(blurred.Pix[index])))
+	}
+	return dst
+}
+
+// edgeStage replaces the image with the strength of its edges using the Sobel operator
+type edgeStage struct{}
+
+func newEdgeStage(param interface{}) (FilterStage, error) {
+	if param != nil {
+		return nil, fmt.Errorf("edge does not take a parameter, got %v", param)
+	}
+	return &edgeStage{}, nil
+}
+
+func (stage *edgeStage) Name() string { return "edge" }
+
+func (stage *edgeStage) Key() string { return "edge" }
+
+func (stage *edgeStage) Apply(img *image.Gray, area image.Rectangle) *image.Gray {
+	return sobel(img)
+}
+
+// morphologyStage grows (dilate) or shrinks (erode) the bright parts of the image
+type morphologyStage struct {
+	radius int
+	dilate bool
+}
+
+func newDilateStage(param interface{}) (FilterStage, error) {
+	radius, err := intParam(param, 1)
+	if err != nil || radius < 1 {
+		return nil, fmt.Errorf("dilate needs a radius of 1 or more, got %v", param)
+	}
+	return &morphologyStage{radius: radius, dilate: true}, nil
+}
+
+func newErodeStage(param interface{}) (FilterStage, error) {
+	radius, err := intParam(param, 1)
+	if err != nil || radius < 1 {
+		return nil, fmt.Errorf("erode needs a radius of 1 or more, got %v", param)
+	}
+	return &morphologyStage{radius: radius}, nil
+}
+
+func (stage *morphologyStage) Name() string {
+	if stage.dilate {
+		return "dilate"
+	}
+	return "erode"
+}
+
+func (stage *morphologyStage) Key() string { return stage.Name() + ":" + strconv.Itoa(stage.radius) }
+
+func (stage *morphologyStage) Apply(img *image.Gray, area image.Rectangle) *image.Gray {
+	return neighbourhood(img, stage.radius, func(values []uint8) uint8 {
+		result := values[0]
+		for _, value := range values {
+			if (stage.dilate && value > result) || (!stage.dilate && value < result) {
+				result = value
+			}
+		}
+		return result
+	})
+}
+
+// invertStage swaps black and white
+type invertStage struct{}
+
+func newInvertStage(param interface{}) (FilterStage, error) {
+	if param != nil {
+		return nil, fmt.Errorf("invert does not take a parameter, got %v", param)
+	}
+	return &invertStage{}, nil
+}
+
+func (stage *invertStage) Name() string { return "invert" }
+
+func (stage *invertStage) Key() string { return "invert" }
+
+func (stage *invertStage) Apply(img *image.Gray, area image.Rectangle) *image.Gray {
+	return mapGray(img, func(value uint8) uint8 {
+		return 255 - value
+	})
+}
+
+// sobel returns the gradient magnitude of every pixel
+func sobel(img *image.Gray) *image.Gray {
+	bounds := img.Bounds()
+	dst := image.NewGray(bounds)
+	at := func(x, y int) int {
+		x = minInt(maxInt(x, bounds.Min.X), bounds.Max.X-1)
+		y = minInt(maxInt(y, bounds.Min.Y), bounds.Max.Y-1)
+		return int(img.Pix[img.PixOffset(x, y)])
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			dst.Pix[dst.PixOffset(x, y)] = clampUint8(math.Sqrt(float64(gx*gx + gy*gy)))
+		}
+	}
+	return dst
+}
+
+// neighbourhood replaces every pixel with the result of reduce over the square of pixels within radius of it
+// pixels outside the image are left out of the square
+func neighbourhood(img *image.Gray, radius int, reduce func(values []uint8) uint8) *image.Gray {
+	bounds := img.Bounds()
+	dst := image.NewGray(bounds)
+	values := make([]uint8, 0, (2*radius+1)*(2*radius+1))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			values = values[:0]
+			for ny := maxInt(y-radius, bounds.Min.Y); ny <= minInt(y+radius, bounds.Max.Y-1); ny++ {
+				for nx := maxInt(x-radius, bounds.Min.X); nx <= minInt(x+radius, bounds.Max.X-1); nx++ {
+					values = append(values, img.Pix[img.PixOffset(nx, ny)])
+				}
+			}
+			dst.Pix[dst.PixOffset(x, y)] = reduce(values)
+		}
+	}
+	return dst
+}
+
+// mapGray returns a copy of img with fn applied to every pixel
+func mapGray(img *image.Gray, fn func(value uint8) uint8) *image.Gray {
+	bounds := img.Bounds()
+	dst := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			dst.Pix[dst.PixOffset(x, y)] = fn(img.Pix[img.PixOffset(x, y)])
+		}
+	}
+	return dst
+}
+
+func clampUint8(value float64) uint8 {
+	if value <= 0 {
+		return 0
+	}
+	if value >= 255 {
+		return 255
+	}
+	return uint8(value + 0.5)
+}
+
+// intParam reads a whole number stage parameter, nil returns fallback
+func intParam(param interface{}, fallback int) (int, error) {
+	switch typedParam := param.(type) {
+	case nil:
+		return fallback, nil
+	case int:
+		return typedParam, nil
+	}
+	return 0, fmt.Errorf("got type %T, need a whole number", param)
+}
+
+// floatParam reads a number stage parameter, nil returns fallback
+func floatParam(param interface{}, fallback float64) (float64, error) {
+	switch typedParam := param.(type) {
+	case nil:
+		return fallback, nil
+	case int:
+		return float64(typedParam), nil
+	case float64:
+		return typedParam, nil
+	}
+	return 0, fmt.Errorf("got type %T, need a number", param)
+}
+
+// outlineStage draws a line of stroke pixels along every edge in the image
+// The line is drawn on the brighter side of the edge so filled shapes turn into outlines
+type outlineStage struct {
+	stroke int
+}
+
+// outlineEdgeDelta is the difference in brightness between two pixels for them to count as an edge
+const outlineEdgeDelta = 48
+
+func newOutlineStage(param interface{}) (FilterStage, error) {
+	stroke, err := intParam(param, 1)
+	if err != nil || stroke < 1 {
+		return nil, fmt.Errorf("outline needs a stroke width of 1 or more, got %v", param)
+	}
+	return &outlineStage{stroke: stroke}, nil
+}
+
+func (stage *outlineStage) Name() string { return "outline" }
+
+func (stage *outlineStage) Key() string { return "outline:" + strconv.Itoa(stage.stroke) }
+
+func (stage *outlineStage) binarizes() bool { return true }
+
+func (stage *outlineStage) Apply(img *image.Gray, area image.Rectangle) *image.Gray {
+	bounds := img.Bounds()
+	dst := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			value := int(img.Pix[img.PixOffset(x, y)])
+		Search:
+			for ny := maxInt(y-stage.stroke, bounds.Min.Y); ny <= minInt(y+stage.stroke, bounds.Max.Y-1); ny++ {
+				for nx := maxInt(x-stage.stroke, bounds.Min.X); nx <= minInt(x+stage.stroke, bounds.Max.X-1); nx++ {
+					if value-int(img.Pix[img.PixOffset(nx, ny)]) >= outlineEdgeDelta {
+						dst.Pix[dst.PixOffset(x, y)] = 255
+						break Search
+					}
+				}
+			}
+		}
+	}
+	return dst
+}
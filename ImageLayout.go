@@ -0,0 +1,270 @@
+package deejdsp
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// ResampleFilter selects the interpolation used when an icon is scaled
+type ResampleFilter int
+
+// Supported resampling filters
+const (
+	ResampleNearest ResampleFilter = iota
+	ResampleBilinear
+	ResampleLanczos
+)
+
+// ScaleMode selects how an icon is fitted into the display
+type ScaleMode int
+
+// Supported scale modes
+const (
+	// ScaleFit scales the icon to fit inside the display keeping its aspect ratio
+	ScaleFit ScaleMode = iota
+	// ScaleFill scales the icon to cover the display and crops what does not fit
+	ScaleFill
+	// ScaleStretch ignores the aspect ratio and stretches the icon over the display
+	ScaleStretch
+	// ScaleInteger only scales by whole number factors so pixel art stays crisp
+	ScaleInteger
+	// ScaleDown shrinks icons that do not fit like ScaleFit but never blows small icons up
+	ScaleDown
+)
+
+// Alignment positions the icon inside the display when it does not fill it
+type Alignment int
+
+// Supported alignments, Start is left or top and End is right or bottom
+const (
+	AlignCenter Alignment = iota
+	AlignStart
+	AlignEnd
+)
+
+// Margins is the amount of pixels kept clear on each side of the display
+type Margins struct {
+	Top    int `yaml:"top"`
+	Bottom int `yaml:"bottom"`
+	Left   int `yaml:"left"`
+	Right  int `yaml:"right"`
+}
+
+// ConvertOptions holds all the settings used by ConvertImageWithOptions
+type ConvertOptions struct {
+	Threshold  int
+	Filter     ResampleFilter
+	Scale      ScaleMode
+	HAlign     Alignment
+	VAlign     Alignment
+	Margins    Margins
+	Background Background
+	// Trim crops empty borders off the icon before it is scaled
+	Trim          TrimMode
+	TrimTolerance int
+	// Pipeline is run on the grayscale image, nil uses DefaultPipeline
+	Pipeline Pipeline
+	// Mode ModeOutline replaces the threshold stages of the pipeline with outlines StrokeWidth pixels wide
+	Mode        ConvertMode
+	StrokeWidth int
+	// Transform rotates, flips or inverts the finished image for the display it is sent to
+	Transform ImageTransform
+	// Profile is the panel the image is generated for, the zero value is the default 128x64 display
+	Profile DisplayProfile
+}
+
+// DefaultConvertOptions returns the options that match the original ConvertImage behaviour
+// Like the resize.Thumbnail it used icons are only ever scaled down
+func DefaultConvertOptions() ConvertOptions {
+	return ConvertOptions{
+		Threshold:  200,
+		Filter:     ResampleNearest,
+		Scale:      ScaleDown,
+		HAlign:     AlignCenter,
+		VAlign:     AlignStart,
+		Background: BackgroundBlack,
+
+		Trim:          TrimOff,
+		TrimTolerance: DefaultTrimTolerance,
+
+		Mode:        ModeThreshold,
+		StrokeWidth: 1,
+
+		Profile: DefaultDisplayProfile(),
+	}
+}
+
+// VariantKey describes the per display and per process options so differently generated images get their own file
+// It is empty when the default pipeline is used without any transform
+func (opts ConvertOptions) VariantKey() string {
+	key := opts.Transform.Key()
+	if opts.Profile.Width != 0 || opts.Profile.Height != 0 {
+		key += opts.Profile.Key()
+	}
+	if opts.Mode == ModeOutline {
+		key += fmt.Sprintf("o%d", opts.StrokeWidth)
+	}
+	if opts.Pipeline != nil {
+		key += "|" + opts.Pipeline.Key()
+	}
+	return key
+}
+
+// ParseResampleFilter converts a config string into a ResampleFilter
+func ParseResampleFilter(value string) (ResampleFilter, error) {
+	switch strings.ToLower(value) {
+	case "nearest", "nearestneighbor":
+		return ResampleNearest, nil
+	case "bilinear":
+		return ResampleBilinear, nil
+	case "lanczos", "lanczos3":
+		return ResampleLanczos, nil
+	}
+	return ResampleNearest, fmt.Errorf("unknown resample filter %q", value)
+}
+
+// ParseScaleMode converts a config string into a ScaleMode
+func ParseScaleMode(value string) (ScaleMode, error) {
+	switch strings.ToLower(value) {
+	case "down", "thumbnail":
+		return ScaleDown, nil
+	case "fit":
+		return ScaleFit, nil
+	case "fill", "crop":
+		return ScaleFill, nil
+	case "stretch":
+		return ScaleStretch, nil
+	case "integer", "integer-scale":
+		return ScaleInteger, nil
+	}
+	return ScaleDown, fmt.Errorf("unknown scale mode %q", value)
+}
+
+// ParseAlignment converts a config string into an Alignment
+// left/top and right/bottom are treated the same so it works for both axes
+func ParseAlignment(value string) (Alignment, error) {
+	switch strings.ToLower(value) {
+	case "center", "centre", "middle":
+		return AlignCenter, nil
+	case "left", "top", "start":
+		return AlignStart, nil
+	case "right", "bottom", "end":
+		return AlignEnd, nil
+	}
+	return AlignCenter, fmt.Errorf("unknown alignment %q", value)
+}
+
+func (filter ResampleFilter) interpolation() resize.InterpolationFunction {
+	switch filter {
+	case ResampleBilinear:
+		return resize.Bilinear
+	case ResampleLanczos:
+		return resize.Lanczos3
+	}
+	return resize.NearestNeighbor
+}
+
+// scaledSize works out how big the icon should be drawn inside an area of areaX by areaY
+func scaledSize(srcX, srcY, areaX, areaY int, mode ScaleMode) (int, int) {
+	switch mode {
+	case ScaleStretch:
+		return areaX, areaY
+	case ScaleFill:
+		// scale by the larger ratio so the area is completely covered
+		if srcX*areaY > srcY*areaX {
+			return (srcX*areaY + srcY/2) / srcY, areaY
+		}
+		return areaX, (srcY*areaX + srcX/2) / srcX
+	case ScaleInteger:
+		if srcX <= areaX && srcY <= areaY {
+			factor := minInt(areaX/srcX, areaY/srcY)
+			return srcX * factor, srcY * factor
+		}
+		divisor := maxInt((srcX+areaX-1)/areaX, (srcY+areaY-1)/areaY)
+		return srcX / divisor, srcY / divisor
+	case ScaleDown:
+		if srcX <= areaX && srcY <= areaY {
+			return srcX, srcY
+		}
+	}
+	// ScaleFit
+	if srcX*areaY > srcY*areaX {
+		return areaX, maxInt((srcY*areaX+srcX/2)/srcX, 1)
+	}
+	return maxInt((srcX*areaY+srcY/2)/srcY, 1), areaY
+}
+
+// alignOffset returns how far from the start of the area an item of size should be placed
+func alignOffset(size, area int, align Alignment) int {
+	switch align {
+	case AlignStart:
+		return 0
+	case AlignEnd:
+		return area - size
+	}
+	return (area - size) / 2
+}
+
+// layoutImage scales and positions srcimg on a canvas of width by height
+// Transparent pixels are blended onto the background from opts
+// It also returns the part of the canvas covered by the icon
+func layoutImage(srcimg image.Image, opts ConvertOptions, width, height int) (*image.RGBA, image.Rectangle) {
+	// vector images are drawn again at their final size so they stay sharp
+	vector, isVector := srcimg.(VectorImage)
+	var content image.Rectangle
+	if isVector {
+		content = ContentBounds(srcimg, opts.Trim, opts.TrimTolerance)
+	}
+	srcimg = trimImage(srcimg, opts)
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	background := image.NewUniform(opts.Background.backgroundColor(srcimg))
+	draw.Draw(canvas, canvas.Bounds(), background, image.ZP, draw.Src)
+
+	area := image.Rect(opts.Margins.Left, opts.Margins.Top, width-opts.Margins.Right, height-opts.Margins.Bottom)
+	srcX, srcY := srcimg.Bounds().Dx(), srcimg.Bounds().Dy()
+	if area.Empty() || srcX == 0 || srcY == 0 {
+		return canvas, image.ZR
+	}
+
+	scale := opts.Scale
+	if isVector && scale == ScaleDown {
+		// vector images have no real size so they are always drawn as big as they fit
+		scale = ScaleFit
+	}
+	scaledX, scaledY := scaledSize(srcX, srcY, area.Dx(), area.Dy(), scale)
+	if scaledX <= 0 || scaledY <= 0 {
+		return canvas, image.ZR
+	}
+
+	filter := opts.Filter.interpolation()
+	if opts.Scale == ScaleInteger && scaledX >= srcX {
+		// upscaling by a whole number should never blur the pixels
+		filter = resize.NearestNeighbor
+	}
+	var resizedImage image.Image
+	if isVector {
+		resizedImage = rasterizeArea(vector, content, scaledX, scaledY)
+	}
+	if resizedImage == nil {
+		resizedImage = srcimg
+		if scaledX != srcX || scaledY != srcY {
+			resizedImage = resize.Resize(uint(scaledX), uint(scaledY), srcimg, filter)
+		}
+	}
+
+	// position the icon inside the area, anything bigger than the area gets cropped
+	placed := image.Rect(0, 0, scaledX, scaledY).Add(image.Pt(
+		area.Min.X+alignOffset(scaledX, area.Dx(), opts.HAlign),
+		area.Min.Y+alignOffset(scaledY, area.Dy(), opts.VAlign),
+	))
+	visible := placed.Intersect(area)
+	srcPoint := resizedImage.Bounds().Min.Add(visible.Min.Sub(placed.Min))
+	draw.Draw(canvas, visible, resizedImage, srcPoint, draw.Over)
+
+	return canvas, visible
+}
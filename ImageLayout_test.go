@@ -0,0 +1,41 @@
+package deejdsp
+
+import (
+	"image"
+	"testing"
+)
+
+func TestScaledSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		srcX, srcY int
+		mode       ScaleMode
+		wantX      int
+		wantY      int
+	}{
+		// small icons are left alone like resize.Thumbnail did
+		{"down small", 32, 32, ScaleDown, 32, 32},
+		{"down big", 256, 256, ScaleDown, 64, 64},
+		{"down wide", 512, 128, ScaleDown, 128, 32},
+		{"fit small", 32, 32, ScaleFit, 64, 64},
+		{"fit big", 256, 256, ScaleFit, 64, 64},
+		{"fill", 256, 256, ScaleFill, 128, 128},
+		{"stretch", 32, 32, ScaleStretch, 128, 64},
+		{"integer small", 20, 20, ScaleInteger, 60, 60},
+		{"integer big", 200, 200, ScaleInteger, 50, 50},
+	}
+	for _, test := range tests {
+		gotX, gotY := scaledSize(test.srcX, test.srcY, 128, 64, test.mode)
+		if gotX != test.wantX || gotY != test.wantY {
+			t.Errorf("%s: got %dx%d, want %dx%d", test.name, gotX, gotY, test.wantX, test.wantY)
+		}
+	}
+}
+
+func TestDefaultConvertOptionsDoNotScaleUp(t *testing.T) {
+	icon := image.NewGray(image.Rect(0, 0, 32, 32))
+	_, area := layoutImage(icon, DefaultConvertOptions(), 128, 64)
+	if area.Dx() != 32 || area.Dy() != 32 {
+		t.Errorf("a 32x32 icon covers %dx%d, want it left at 32x32", area.Dx(), area.Dy())
+	}
+}
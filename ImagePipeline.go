@@ -0,0 +1,194 @@
+package deejdsp
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+)
+
+// FilterStage is a single named step of an image Pipeline
+// Stages work on the grayscale version of the laid out image, area is the part of it covered by the icon
+// Key is the name and parameters of the stage, it ends up in the names of generated files so it has to stay the same between versions
+type FilterStage interface {
+	Name() string
+	Key() string
+	Apply(img *image.Gray, area image.Rectangle) *image.Gray
+}
+
+// Pipeline is an ordered list of filter stages run on every converted image
+// A nil Pipeline runs DefaultPipeline
+type Pipeline []FilterStage
+
+// ConvertMode selects how the grayscale image is turned into black and white
+type ConvertMode int
+
+// Supported conversion modes
+const (
+	// ModeThreshold fills everything brighter than the threshold
+	ModeThreshold ConvertMode = iota
+	// ModeOutline only draws the edges of shapes, good for flat icons that would turn into a solid blob
+	ModeOutline
+)
+
+// ParseConvertMode converts a config string into a ConvertMode
+func ParseConvertMode(value string) (ConvertMode, error) {
+	switch strings.ToLower(value) {
+	case "threshold":
+		return ModeThreshold, nil
+	case "outline", "edge":
+		return ModeOutline, nil
+	}
+	return ModeThreshold, fmt.Errorf("unknown conversion mode %q", value)
+}
+
+// stageFactory builds a stage from the parameter given in the config, param is nil if none was given
+type stageFactory func(param interface{}) (FilterStage, error)
+
+var stageFactories = map[string]stageFactory{
+	"threshold":  newThresholdStage,
+	"brightness": newBrightnessStage,
+	"contrast":   newContrastStage,
+	"gamma":      newGammaStage,
+	"sharpen":    newSharpenStage,
+	"edge":       newEdgeStage,
+	"dilate":     newDilateStage,
+	"erode":      newErodeStage,
+	"invert":     newInvertStage,
+	"outline":    newOutlineStage,
+}
+
+// binarizer is implemented by stages that already output a black and white image
+type binarizer interface {
+	binarizes() bool
+}
+
+// DefaultPipeline returns the pipeline that matches the original ConvertImage behaviour
+// It only thresholds the image using the threshold from ConvertOptions
+func DefaultPipeline() Pipeline {
+	return Pipeline{&thresholdStage{level: thresholdFromOptions}}
+}
+
+// FilterStageNames returns the names that can be used in a pipeline config
+func FilterStageNames() []string {
+	names := make([]string, 0, len(stageFactories))
+	for name := range stageFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewFilterStage creates a stage by its config name
+func NewFilterStage(name string, param interface{}) (FilterStage, error) {
+	factory, ok := stageFactories[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown filter stage %q, need one of %v", name, FilterStageNames())
+	}
+	stage, err := factory(param)
+	if err != nil {
+		return nil, fmt.Errorf("filter stage %q: %w", name, err)
+	}
+	return stage, nil
+}
+
+// ParsePipeline builds a pipeline from the yaml list it was configured with
+// Each entry is either a stage name or a map of a stage name to its parameter
+//   - sharpen
+//   - contrast: 1.5
+//   - threshold: auto
+func ParsePipeline(spec []interface{}) (Pipeline, error) {
+	pipeline := Pipeline{}
+	for index, entry := range spec {
+		switch typedEntry := entry.(type) {
+		case string:
+			stage, err := NewFilterStage(typedEntry, nil)
+			if err != nil {
+				return nil, err
+			}
+			pipeline = append(pipeline, stage)
+		case map[string]interface{}:
+			if len(typedEntry) != 1 {
+				return nil, fmt.Errorf("pipeline entry %d: need exactly one stage per entry, got %d", index, len(typedEntry))
+			}
+			for name, param := range typedEntry {
+				stage, err := NewFilterStage(name, param)
+				if err != nil {
+					return nil, err
+				}
+				pipeline = append(pipeline, stage)
+			}
+		default:
+			return nil, fmt.Errorf("pipeline entry %d: got type %T, need string or map", index, entry)
+		}
+	}
+	return pipeline, nil
+}
+
+// Names returns the name of each stage in order
+func (pipeline Pipeline) Names() []string {
+	names := make([]string, len(pipeline))
+	for index, stage := range pipeline {
+		names[index] = stage.Name()
+	}
+	return names
+}
+
+// Key returns a string describing the stages and their parameters used to tell generated files apart
+// A nil pipeline returns an empty string
+func (pipeline Pipeline) Key() string {
+	var key string
+	for _, stage := range pipeline {
+		key += stage.Key() + ";"
+	}
+	return key
+}
+
+// withOutline returns a copy of the pipeline with every threshold stage replaced by an outline stage
+// Pipelines that never turn the image black and white get an outline stage at the end instead of the threshold run adds
+func (pipeline Pipeline) withOutline(stroke int) Pipeline {
+	if pipeline == nil {
+		pipeline = DefaultPipeline()
+	}
+	outlined := make(Pipeline, 0, len(pipeline)+1)
+	binarized := false
+	for _, stage := range pipeline {
+		if _, ok := stage.(*thresholdStage); ok {
+			stage = &outlineStage{stroke: stroke}
+		}
+		if bw, ok := stage.(binarizer); ok && bw.binarizes() {
+			binarized = true
+		}
+		outlined = append(outlined, stage)
+	}
+	if !binarized {
+		outlined = append(outlined, &outlineStage{stroke: stroke})
+	}
+	return outlined
+}
+
+// run applies every stage to the image and makes sure the result is black and white
+func (pipeline Pipeline) run(img *image.Gray, area image.Rectangle, threshold int) *image.Gray {
+	if pipeline == nil {
+		pipeline = DefaultPipeline()
+	}
+
+	thresholded := false
+	for _, stage := range pipeline {
+		if ts, ok := stage.(*thresholdStage); ok {
+			img = ts.threshold(img, area, threshold)
+			thresholded = true
+			continue
+		}
+		img = stage.Apply(img, area)
+		if bw, ok := stage.(binarizer); ok && bw.binarizes() {
+			thresholded = true
+		}
+	}
+
+	// a pipeline without a threshold stage still has to end up as black and white
+	if !thresholded {
+		img = (&thresholdStage{level: thresholdFromOptions}).threshold(img, area, threshold)
+	}
+	return img
+}
@@ -0,0 +1,66 @@
+package deejdsp
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testSquare returns a white square on black
+func testSquare() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 32, 32))
+	for y := 8; y < 24; y++ {
+		for x := 8; x < 24; x++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	return img
+}
+
+func TestPipelineWithOutline(t *testing.T) {
+	tests := []struct {
+		name string
+		spec []interface{}
+	}{
+		{"default", nil},
+		{"threshold", []interface{}{"sharpen", "threshold"}},
+		// without a threshold stage the outline has to be added at the end
+		{"no threshold", []interface{}{"sharpen"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var pipeline Pipeline
+			if test.spec != nil {
+				var err error
+				if pipeline, err = ParsePipeline(test.spec); err != nil {
+					t.Fatal(err)
+				}
+			}
+			img := testSquare()
+			filled := pipeline.run(img, img.Bounds(), 128)
+			outlined := pipeline.withOutline(1).run(img, img.Bounds(), 128)
+
+			// the middle of the square is lit when filled and dark when outlined, its edge is lit in both
+			if filled.GrayAt(16, 16).Y != 255 {
+				t.Errorf("middle of the filled square is %d, want 255", filled.GrayAt(16, 16).Y)
+			}
+			if outlined.GrayAt(16, 16).Y != 0 {
+				t.Errorf("middle of the outlined square is %d, want 0", outlined.GrayAt(16, 16).Y)
+			}
+			if outlined.GrayAt(8, 16).Y != 255 {
+				t.Errorf("edge of the outlined square is %d, want 255", outlined.GrayAt(8, 16).Y)
+			}
+		})
+	}
+}
+
+func TestPipelineWithOutlineKeepsStages(t *testing.T) {
+	pipeline, err := ParsePipeline([]interface{}{"sharpen", "threshold", "invert"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the threshold is swapped in place so the stages after it still run on the outline
+	if key := pipeline.withOutline(2).Key(); key != "sharpen:1;outline:2;invert;" {
+		t.Errorf("got %q", key)
+	}
+}
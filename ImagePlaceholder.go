@@ -0,0 +1,200 @@
+package deejdsp
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"unicode"
+)
+
+// PlaceholderText selects what is written on a placeholder
+type PlaceholderText int
+
+// Supported placeholder texts
+const (
+	// PlaceholderInitials writes the first letters of the process name, like VC for VoiceChat.exe
+	PlaceholderInitials PlaceholderText = iota
+	// PlaceholderName writes the process name without its extension, cut short if it does not fit
+	PlaceholderName
+)
+
+// placeholderPadding is the gap kept between the text and the frame or the edge of the display
+const placeholderPadding = 2
+
+// ParsePlaceholderText converts a config string into a PlaceholderText
+func ParsePlaceholderText(value string) (PlaceholderText, error) {
+	switch strings.ToLower(value) {
+	case "initials":
+		return PlaceholderInitials, nil
+	case "name":
+		return PlaceholderName, nil
+	}
+	return PlaceholderInitials, fmt.Errorf("unknown placeholder text %q", value)
+}
+
+// PlaceholderOptions controls how placeholders are drawn
+type PlaceholderOptions struct {
+	Text PlaceholderText
+	// Frame draws a border around the edge of the display
+	Frame bool
+	// Font is the font the text is written in, nil uses DefaultPixelFont
+	Font *PixelFont
+}
+
+// DefaultPlaceholderOptions returns initials in a frame
+func DefaultPlaceholderOptions() PlaceholderOptions {
+	return PlaceholderOptions{Text: PlaceholderInitials, Frame: true}
+}
+
+// Key describes the options so placeholders drawn differently are saved to different files
+func (opts PlaceholderOptions) Key() string {
+	key := "initials"
+	if opts.Text == PlaceholderName {
+		key = "name"
+	}
+	if opts.Frame {
+		key += "+frame"
+	}
+	return key
+}
+
+// GeneratePlaceholder draws a placeholder for process on the display described by opts
+// It also returns the options to convert it with, these keep the transform and profile of opts
+// but do not scale or filter the placeholder so the font stays sharp
+func GeneratePlaceholder(process string, opts ConvertOptions, placeholder PlaceholderOptions) (image.Image, ConvertOptions) {
+	profile := opts.Profile
+	if profile.Width == 0 && profile.Height == 0 {
+		profile = DefaultDisplayProfile()
+	}
+	width, height := profile.Width, profile.Height
+	if opts.Transform.swapsAxes() {
+		width, height = height, width
+	}
+
+	plain := DefaultConvertOptions()
+	plain.Scale = ScaleInteger
+	plain.Threshold = 128
+	plain.Transform = opts.Transform
+	plain.Profile = profile
+	return DrawPlaceholder(process, width, height, placeholder), plain
+}
+
+// DrawPlaceholder draws the text for process as big as it fits on a width by height image
+// The text is white on black and every pixel of the font is scaled by a whole number
+func DrawPlaceholder(process string, width, height int, opts PlaceholderOptions) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	font := opts.Font
+	if font == nil {
+		font = DefaultPixelFont
+	}
+
+	inset := placeholderPadding
+	if opts.Frame {
+		drawPlaceholderFrame(img)
+		inset++
+	}
+	areaX, areaY := width-2*inset, height-2*inset
+	if areaX < font.Width || areaY < font.Height {
+		return img
+	}
+
+	text := ProcessInitials(process)
+	if opts.Text == PlaceholderName {
+		text = ProcessShortName(process)
+	}
+	// cut the text down to what fits at the smallest size
+	if fits := (areaX + 1) / font.Advance(1); len([]rune(text)) > fits {
+		text = string([]rune(text)[:fits])
+	}
+	if text == "" {
+		return img
+	}
+
+	textX, _ := font.MeasureString(text, 1)
+	scale := maxInt(minInt(areaX/textX, areaY/font.Height), 1)
+	textX, textY := font.MeasureString(text, scale)
+	font.DrawString(img, (width-textX)/2, (height-textY)/2, text, scale, color.Gray{Y: 255})
+	return img
+}
+
+// drawPlaceholderFrame draws a one pixel border around img with the corners cut off
+func drawPlaceholderFrame(img *image.Gray) {
+	bounds := img.Bounds()
+	lit := color.Gray{Y: 255}
+	for x := bounds.Min.X + 1; x < bounds.Max.X-1; x++ {
+		img.SetGray(x, bounds.Min.Y, lit)
+		img.SetGray(x, bounds.Max.Y-1, lit)
+	}
+	for y := bounds.Min.Y + 1; y < bounds.Max.Y-1; y++ {
+		img.SetGray(bounds.Min.X, y, lit)
+		img.SetGray(bounds.Max.X-1, y, lit)
+	}
+}
+
+// processWords splits a process name into words at punctuation, spaces, camel case and numbers
+// The path and extension are dropped first, numbers are returned on their own
+func processWords(process string) (words []string, numbers []string) {
+	var word []rune
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		if strings.IndexFunc(string(word), unicode.IsLetter) < 0 {
+			numbers = append(numbers, string(word))
+		} else {
+			words = append(words, string(word))
+		}
+		word = nil
+	}
+	var previous rune
+	for _, r := range processBaseName(process) {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+		case unicode.IsUpper(r) && unicode.IsLower(previous):
+			flush()
+			word = append(word, r)
+		case unicode.IsDigit(r) != unicode.IsDigit(previous) && len(word) > 0:
+			flush()
+			word = append(word, r)
+		default:
+			word = append(word, r)
+		}
+		previous = r
+	}
+	flush()
+	return words, numbers
+}
+
+// ProcessInitials returns the first letters of the first two words of a process name
+// Names that are a single word use its first two letters, like Sp for spotify.exe
+// Numbers are only used when the name has no letters
+func ProcessInitials(process string) string {
+	words, numbers := processWords(process)
+	if len(words) == 0 {
+		words = numbers
+	}
+	switch {
+	case len(words) == 0:
+		return "?"
+	case len(words) == 1:
+		letters := []rune(words[0])
+		if len(letters) == 1 {
+			return strings.ToUpper(string(letters))
+		}
+		return strings.ToUpper(string(letters[0])) + strings.ToLower(string(letters[1]))
+	}
+	first, second := []rune(words[0]), []rune(words[1])
+	return strings.ToUpper(string(first[0]) + string(second[0]))
+}
+
+// ProcessShortName returns the name of a process without its path and extension, starting with a capital
+func ProcessShortName(process string) string {
+	name := []rune(processBaseName(process))
+	if len(name) == 0 {
+		return "?"
+	}
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
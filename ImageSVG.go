@@ -0,0 +1,144 @@
+package deejdsp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// svgMinRasterSize is the smallest longest side an svg is drawn at when it is used as a normal image
+// Trimming and picking a background look at this drawing so tiny view boxes are scaled up first
+const svgMinRasterSize = 256
+
+// svgMaxRasterSize stops an svg with a huge view box or a tiny trimmed area from using all of the memory
+const svgMaxRasterSize = 4096
+
+// svgs are registered for programs that do not use bimage, its .b fallback is registered first and matches every file
+// so code in this package decodes images with decodeImage
+func init() {
+	image.RegisterFormat("svg", "<svg", decodeSVGImage, decodeSVGConfig)
+	image.RegisterFormat("svg", "<?xml", decodeSVGImage, decodeSVGConfig)
+}
+
+// decodeImage decodes an svg or any image.Decode format from r
+func decodeImage(r io.Reader) (image.Image, error) {
+	buffered := bufio.NewReader(r)
+	if start, _ := buffered.Peek(5); bytes.HasPrefix(start, []byte("<svg")) || bytes.HasPrefix(start, []byte("<?xml")) {
+		return DecodeSVG(buffered)
+	}
+	img, _, err := image.Decode(buffered)
+	return img, err
+}
+
+// VectorImage is an image that can be drawn again at any size
+// layoutImage draws vector images at the size they end up on the display instead of resizing them
+type VectorImage interface {
+	image.Image
+	Rasterize(width, height int) image.Image
+}
+
+// SVGImage is a decoded svg
+// As an image.Image it is the svg drawn at its view box size, scaled up to at least svgMinRasterSize
+type SVGImage struct {
+	lock   sync.Mutex
+	icon   *oksvg.SvgIcon
+	raster image.Image
+}
+
+// DecodeSVG reads an svg from r
+func DecodeSVG(r io.Reader) (*SVGImage, error) {
+	icon, err := oksvg.ReadIconStream(r, oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, err
+	}
+	if icon.ViewBox.W <= 0 || icon.ViewBox.H <= 0 {
+		return nil, errors.New("svg has no size")
+	}
+	img := &SVGImage{icon: icon}
+	width, height := img.naturalSize()
+	img.raster = img.Rasterize(width, height)
+	return img, nil
+}
+
+// decodeSVGImage is the image.Decode hook for svgs
+func decodeSVGImage(r io.Reader) (image.Image, error) {
+	return DecodeSVG(r)
+}
+
+// decodeSVGConfig is the image.DecodeConfig hook for svgs
+func decodeSVGConfig(r io.Reader) (image.Config, error) {
+	img, err := DecodeSVG(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: img.ColorModel(), Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}, nil
+}
+
+// naturalSize returns the size of the view box scaled up so the longest side is at least svgMinRasterSize
+func (img *SVGImage) naturalSize() (int, int) {
+	width, height := img.icon.ViewBox.W, img.icon.ViewBox.H
+	if longest := math.Max(width, height); longest < svgMinRasterSize {
+		width, height = width*svgMinRasterSize/longest, height*svgMinRasterSize/longest
+	}
+	return maxInt(int(math.Round(width)), 1), maxInt(int(math.Round(height)), 1)
+}
+
+// Rasterize draws the whole svg stretched to width by height
+func (img *SVGImage) Rasterize(width, height int) image.Image {
+	width, height = minInt(maxInt(width, 1), svgMaxRasterSize), minInt(maxInt(height, 1), svgMaxRasterSize)
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	img.lock.Lock()
+	defer img.lock.Unlock()
+	img.icon.SetTarget(0, 0, float64(width), float64(height))
+	scanner := rasterx.NewScannerGV(width, height, canvas, canvas.Bounds())
+	img.icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+	return canvas
+}
+
+// ColorModel returns the colour model of the drawn svg
+func (img *SVGImage) ColorModel() color.Model {
+	return img.raster.ColorModel()
+}
+
+// Bounds returns the size the svg is drawn at as a normal image
+func (img *SVGImage) Bounds() image.Rectangle {
+	return img.raster.Bounds()
+}
+
+// At returns the colour of a pixel of the drawn svg
+func (img *SVGImage) At(x, y int) color.Color {
+	return img.raster.At(x, y)
+}
+
+// rasterizeArea draws the part of a vector image inside area so it comes out width by height
+// area is in the coordinates of the vector image drawn at its normal size
+// nil is returned if the drawing would be too big, the normal drawing has to be resized instead
+func rasterizeArea(vector VectorImage, area image.Rectangle, width, height int) image.Image {
+	full := vector.Bounds()
+	if area.Empty() {
+		area = full
+	}
+	scaleX := float64(width) / float64(area.Dx())
+	scaleY := float64(height) / float64(area.Dy())
+	drawn := vector.Rasterize(int(math.Round(float64(full.Dx())*scaleX)), int(math.Round(float64(full.Dy())*scaleY)))
+
+	if drawn.Bounds().Dx() < width || drawn.Bounds().Dy() < height {
+		// the drawing was clamped to svgMaxRasterSize
+		return nil
+	}
+	// rounding can push the area a pixel past the edge of the drawing
+	origin := image.Pt(
+		minInt(int(math.Round(float64(area.Min.X-full.Min.X)*scaleX)), drawn.Bounds().Dx()-width),
+		minInt(int(math.Round(float64(area.Min.Y-full.Min.Y)*scaleY)), drawn.Bounds().Dy()-height),
+	)
+	return cropImage(drawn, image.Rect(0, 0, width, height).Add(origin))
+}
@@ -0,0 +1,22 @@
+package deejdsp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeImageSVG(t *testing.T) {
+	// bimage registers its .b fallback before svgs so image.Decode can not find them, decodeImage has to
+	for _, svg := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><rect width="5" height="5" fill="#fff"/></svg>`,
+		`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><rect width="5" height="5" fill="#fff"/></svg>`,
+	} {
+		img, err := decodeImage(strings.NewReader(svg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := img.(*SVGImage); !ok {
+			t.Errorf("got %T, want an svg", img)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package deejdsp
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"strings"
+	"unicode"
+
+	"github.com/jax-b/deejdsp/bimage"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// DefaultTextThreshold is how opaque a pixel of an anti-aliased glyph has to be to be lit
+const DefaultTextThreshold = 128
+
+// TextFont is a font text can be drawn in
+// Sizes are the height of the font in pixels, PixelFont only has sizes that are whole multiples of its height
+type TextFont interface {
+	// Face returns the font at size
+	Face(size int) (font.Face, error)
+	// Sizes returns the sizes between min and max the font can be drawn at, biggest first
+	Sizes(min, max int) []int
+}
+
+// VectorFont is a TrueType or OpenType font
+type VectorFont struct {
+	Font *opentype.Font
+}
+
+// TextOptions controls how RenderText lays out text
+type TextOptions struct {
+	// Font is the font to draw in, nil uses DefaultPixelFont
+	Font TextFont
+	// Size is the biggest size to draw at, 0 fills the display
+	// Text that does not fit is shrunk down to MinSize, 0 lets it go down to the smallest size of the font
+	Size    int
+	MinSize int
+	HAlign  Alignment
+	VAlign  Alignment
+	// Wrap breaks lines between words so they fit the width of the display, otherwise lines are only broken at newlines
+	Wrap bool
+	// LineSpacing is the number of extra pixels between lines
+	LineSpacing int
+	Margins     Margins
+	// Threshold is how opaque a pixel of a glyph has to be to be lit, 0 uses DefaultTextThreshold
+	Threshold int
+	// Transform rotates, flips or inverts the finished image for the display it is sent to
+	Transform ImageTransform
+	// Profile is the panel the text is drawn for, the zero value is the default 128x64 display
+	Profile DisplayProfile
+}
+
+// textLayout is text broken into lines at one size
+type textLayout struct {
+	face   font.Face
+	lines  []string
+	width  int
+	height int
+}
+
+// LoadFont reads a TrueType or OpenType font file
+func LoadFont(filename string) (*VectorFont, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFont(data)
+}
+
+// ParseFont reads a TrueType or OpenType font from the contents of a font file
+func ParseFont(data []byte) (*VectorFont, error) {
+	parsed, err := opentype.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return &VectorFont{Font: parsed}, nil
+}
+
+// Face returns the font with an em of size pixels
+// Outlines are hinted to whole pixels so stems do not end up as half lit columns on a black and white display
+func (f *VectorFont) Face(size int) (font.Face, error) {
+	return opentype.NewFace(f.Font, &opentype.FaceOptions{Size: float64(size), DPI: 72, Hinting: font.HintingFull})
+}
+
+// Sizes returns every size from max down to min, anything below 6 pixels can not be read
+func (f *VectorFont) Sizes(min, max int) []int {
+	var sizes []int
+	for size := max; size >= maxInt(min, 6); size-- {
+		sizes = append(sizes, size)
+	}
+	return sizes
+}
+
+// Face returns the font scaled up by size divided by its height
+func (f *PixelFont) Face(size int) (font.Face, error) {
+	return &pixelFace{font: f, scale: maxInt(size/f.Height, 1)}, nil
+}
+
+// Sizes returns the whole multiples of the height of the font from max down to min
+// The font is always drawn at its own height even if that is more than max
+func (f *PixelFont) Sizes(min, max int) []int {
+	var sizes []int
+	for scale := max / f.Height; scale >= 1 && scale*f.Height >= min; scale-- {
+		sizes = append(sizes, scale*f.Height)
+	}
+	if len(sizes) == 0 {
+		sizes = append(sizes, f.Height)
+	}
+	return sizes
+}
+
+// RenderText draws text for the display in opts and returns the contents of a .b file
+// The result can be sent to the sd card for SetImage or straight to the display with PushFramebuffer
+func RenderText(text string, opts TextOptions) ([]byte, error) {
+	profile := opts.Profile
+	if profile.Width == 0 && profile.Height == 0 {
+		profile = DefaultDisplayProfile()
+	}
+	if profile.Controller.Color() {
+		return nil, errors.New("text can only be rendered for black and white displays")
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+
+	width, height := profile.Width, profile.Height
+	if opts.Transform.swapsAxes() {
+		width, height = height, width
+	}
+	img, err := DrawText(text, width, height, opts)
+	if err != nil {
+		return nil, err
+	}
+	return bimage.Marshal(opts.Transform.apply(img))
+}
+
+// DrawText draws text white on black on a width by height image
+// The biggest size that fits inside the margins is used, if even the smallest does not fit the text is cut off
+func DrawText(text string, width, height int, opts TextOptions) (*image.Gray, error) {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	area := image.Rect(opts.Margins.Left, opts.Margins.Top, width-opts.Margins.Right, height-opts.Margins.Bottom)
+	if area.Empty() {
+		return img, nil
+	}
+	textFont := opts.Font
+	if textFont == nil {
+		textFont = DefaultPixelFont
+	}
+	maxSize := opts.Size
+	if maxSize <= 0 {
+		maxSize = area.Dy()
+	}
+
+	var layout *textLayout
+	for _, size := range textFont.Sizes(opts.MinSize, maxSize) {
+		face, err := textFont.Face(size)
+		if err != nil {
+			return nil, err
+		}
+		layout = layoutText(text, face, area.Dx(), opts)
+		if layout.width <= area.Dx() && layout.height <= area.Dy() {
+			break
+		}
+	}
+	if layout == nil {
+		return img, nil
+	}
+
+	// glyphs are drawn anti-aliased and then thresholded so every pixel is either on or off
+	mask := image.NewAlpha(area)
+	metrics := layout.face.Metrics()
+	drawer := &font.Drawer{Dst: mask, Src: image.Opaque, Face: layout.face}
+	y := area.Min.Y + alignOffset(layout.height, area.Dy(), opts.VAlign) + metrics.Ascent.Ceil()
+	for _, line := range layout.lines {
+		bounds, _ := font.BoundString(layout.face, line)
+		x := area.Min.X + alignOffset(inkWidth(layout.face, line), area.Dx(), opts.HAlign) - bounds.Min.X.Floor()
+		drawer.Dot = fixed.P(x, y)
+		drawer.DrawString(line)
+		y += lineAdvance(layout.face, opts.LineSpacing)
+	}
+
+	threshold := opts.Threshold
+	if threshold <= 0 {
+		threshold = DefaultTextThreshold
+	}
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			if int(mask.AlphaAt(x, y).A) >= threshold {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img, nil
+}
+
+// lineAdvance returns the distance between the baselines of two lines
+func lineAdvance(face font.Face, spacing int) int {
+	return face.Metrics().Height.Ceil() + spacing
+}
+
+// layoutText breaks text into lines for face and measures them
+// The width is of the widest line, the height is from the top of the first line to the bottom of the last
+func layoutText(text string, face font.Face, width int, opts TextOptions) *textLayout {
+	layout := &textLayout{face: face}
+	for _, paragraph := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		if opts.Wrap {
+			layout.lines = append(layout.lines, wrapLine(paragraph, face, width)...)
+		} else {
+			layout.lines = append(layout.lines, paragraph)
+		}
+	}
+	for _, line := range layout.lines {
+		layout.width = maxInt(layout.width, inkWidth(face, line))
+	}
+	metrics := face.Metrics()
+	layout.height = (len(layout.lines)-1)*lineAdvance(face, opts.LineSpacing) + metrics.Ascent.Ceil() + metrics.Descent.Ceil()
+	return layout
+}
+
+// inkWidth returns how many columns text covers when drawn in face
+func inkWidth(face font.Face, text string) int {
+	bounds, _ := font.BoundString(face, text)
+	return (bounds.Max.X - bounds.Min.X).Ceil()
+}
+
+// wrapLine breaks a line between words so every part fits in width
+// Words that are wider than width on their own are broken between letters
+func wrapLine(line string, face font.Face, width int) []string {
+	var lines []string
+	current := ""
+	for _, word := range strings.FieldsFunc(line, unicode.IsSpace) {
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if inkWidth(face, candidate) <= width {
+			current = candidate
+			continue
+		}
+		if current != "" {
+			lines = append(lines, current)
+		}
+		current = word
+		for inkWidth(face, current) > width {
+			letters := []rune(current)
+			fits := 1
+			for fits < len(letters) && inkWidth(face, string(letters[:fits+1])) <= width {
+				fits++
+			}
+			if fits == len(letters) {
+				break
+			}
+			lines = append(lines, string(letters[:fits]))
+			current = string(letters[fits:])
+		}
+	}
+	return append(lines, current)
+}
+
+// pixelFace draws a PixelFont through the font.Face interface, every pixel of the font becomes a scale by scale square
+type pixelFace struct {
+	font  *PixelFont
+	scale int
+}
+
+// Close does nothing, the face holds no resources
+func (face *pixelFace) Close() error {
+	return nil
+}
+
+// Glyph draws r with its bottom left corner on dot
+func (face *pixelFace) Glyph(dot fixed.Point26_6, r rune) (dr image.Rectangle, mask image.Image, maskp image.Point, advance fixed.Int26_6, ok bool) {
+	glyph := image.NewAlpha(image.Rect(0, 0, face.font.Width*face.scale, face.font.Height*face.scale))
+	face.font.DrawString(glyph, 0, 0, string(r), face.scale, color.Opaque)
+	origin := image.Pt(dot.X.Round(), dot.Y.Round()-glyph.Rect.Dy())
+	return glyph.Rect.Add(origin), glyph, image.ZP, fixed.I(face.font.Advance(face.scale)), true
+}
+
+// GlyphBounds returns the cell of a glyph, the gap between letters is not included
+func (face *pixelFace) GlyphBounds(r rune) (bounds fixed.Rectangle26_6, advance fixed.Int26_6, ok bool) {
+	bounds = fixed.R(0, -face.font.Height*face.scale, face.font.Width*face.scale, 0)
+	return bounds, fixed.I(face.font.Advance(face.scale)), true
+}
+
+// GlyphAdvance returns the width of a glyph and the gap after it
+func (face *pixelFace) GlyphAdvance(r rune) (advance fixed.Int26_6, ok bool) {
+	return fixed.I(face.font.Advance(face.scale)), true
+}
+
+// Kern returns 0, pixel fonts are fixed width
+func (face *pixelFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	return 0
+}
+
+// Metrics returns the size of a line, there is a one pixel gap between lines before scaling
+func (face *pixelFace) Metrics() font.Metrics {
+	return font.Metrics{
+		Height:    fixed.I((face.font.Height + 1) * face.scale),
+		Ascent:    fixed.I(face.font.Height * face.scale),
+		Descent:   0,
+		XHeight:   fixed.I(face.font.Height * face.scale * 5 / 7),
+		CapHeight: fixed.I(face.font.Height * face.scale),
+	}
+}
@@ -0,0 +1,157 @@
+package deejdsp
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Special values that can be passed to ConvertImage in place of a fixed threshold
+const (
+	// ThresholdOtsu picks a global threshold for every image using Otsu's method
+	ThresholdOtsu = -1
+	// ThresholdAdaptive compares every pixel against the mean of the pixels around it
+	ThresholdAdaptive = -2
+)
+
+// adaptiveSensitivity is how far under the local mean (in percent) a pixel can be and still be white
+const adaptiveSensitivity = 15
+
+// adaptiveMinVariance is the variance a neighbourhood needs before its mean is trusted over the global threshold
+const adaptiveMinVariance = 64
+
+// luminance returns the brightness of a colour using the same gamma corrected formula as ssd1306FilePrep.ConvertBW
+func luminance(c color.Color) uint8 {
+	rr, gg, bb, _ := c.RGBA()
+	r := math.Pow(float64(rr), 2.2)
+	g := math.Pow(float64(gg), 2.2)
+	b := math.Pow(float64(bb), 2.2)
+	m := math.Pow(0.2125*r+0.7154*g+0.0721*b, 1/2.2)
+	return uint8(uint16(m+0.5) >> 8)
+}
+
+// toLuminance converts an image into a grayscale image starting at 0,0
+func toLuminance(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			gray.Pix[y*gray.Stride+x] = luminance(img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return gray
+}
+
+// OtsuThreshold calculates the threshold that best splits the image into a foreground and background
+// The returned value can be passed straight to ConvertImage
+func OtsuThreshold(img image.Image) int {
+	var histogram [256]int
+	gray := toLuminance(img)
+	for _, value := range gray.Pix {
+		histogram[value]++
+	}
+
+	total := len(gray.Pix)
+	if total == 0 {
+		return 128
+	}
+
+	var sum float64
+	for level, count := range histogram {
+		sum += float64(level * count)
+	}
+
+	var sumBackground float64
+	var weightBackground int
+	var bestVariance float64
+	best := 0
+	for level, count := range histogram {
+		weightBackground += count
+		if weightBackground == 0 {
+			continue
+		}
+		weightForeground := total - weightBackground
+		if weightForeground == 0 {
+			break
+		}
+		sumBackground += float64(level * count)
+
+		meanBackground := sumBackground / float64(weightBackground)
+		meanForeground := (sum - sumBackground) / float64(weightForeground)
+		variance := float64(weightBackground) * float64(weightForeground) * (meanBackground - meanForeground) * (meanBackground - meanForeground)
+		if variance > bestVariance {
+			bestVariance = variance
+			best = level
+		}
+	}
+
+	// pixels equal to the threshold are white so move one past the last background level
+	return best + 1
+}
+
+// AdaptiveThreshold converts an image to black and white by comparing each pixel with the mean of its neighbourhood
+// This uses the Bradley-Roth method with a window of an eighth of the image width
+// Flat areas without enough contrast fall back to the Otsu threshold of the whole image
+func AdaptiveThreshold(img image.Image) *image.Gray {
+	gray := toLuminance(img)
+	w, h := gray.Bounds().Dx(), gray.Bounds().Dy()
+	bwimage := image.NewGray(gray.Bounds())
+	global := OtsuThreshold(gray)
+
+	// build integral images so every window sum is only four lookups
+	integral := make([]int, (w+1)*(h+1))
+	integralSq := make([]int, (w+1)*(h+1))
+	for y := 1; y <= h; y++ {
+		rowSum, rowSumSq := 0, 0
+		for x := 1; x <= w; x++ {
+			value := int(gray.Pix[(y-1)*gray.Stride+(x-1)])
+			rowSum += value
+			rowSumSq += value * value
+			integral[y*(w+1)+x] = integral[(y-1)*(w+1)+x] + rowSum
+			integralSq[y*(w+1)+x] = integralSq[(y-1)*(w+1)+x] + rowSumSq
+		}
+	}
+	windowSum := func(table []int, x1, y1, x2, y2 int) int {
+		return table[y2*(w+1)+x2] - table[y1*(w+1)+x2] - table[y2*(w+1)+x1] + table[y1*(w+1)+x1]
+	}
+
+	radius := w / 16
+	if radius < 1 {
+		radius = 1
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			x1, y1 := maxInt(x-radius, 0), maxInt(y-radius, 0)
+			x2, y2 := minInt(x+radius+1, w), minInt(y+radius+1, h)
+			count := (x2 - x1) * (y2 - y1)
+			sum := windowSum(integral, x1, y1, x2, y2)
+			sumSq := windowSum(integralSq, x1, y1, x2, y2)
+
+			value := int(gray.Pix[y*gray.Stride+x])
+			var white bool
+			if count*sumSq-sum*sum < count*count*adaptiveMinVariance {
+				white = value >= global
+			} else {
+				white = value*count*100 >= sum*(100-adaptiveSensitivity)
+			}
+			if white {
+				bwimage.Pix[y*bwimage.Stride+x] = 255
+			}
+		}
+	}
+	return bwimage
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,143 @@
+package deejdsp
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// ImageTransform holds the orientation and colour changes for a single display
+// Rotation is clockwise and is applied before flipping
+type ImageTransform struct {
+	Invert bool
+	Rotate int
+	FlipH  bool
+	FlipV  bool
+}
+
+// IsIdentity reports if the transform leaves the image untouched
+func (transform ImageTransform) IsIdentity() bool {
+	return transform == ImageTransform{}
+}
+
+// Key returns a short string describing the transform used to tell generated files apart
+// An identity transform returns an empty string
+func (transform ImageTransform) Key() string {
+	var key string
+	if transform.Rotate != 0 {
+		key += fmt.Sprintf("r%d", transform.Rotate)
+	}
+	if transform.FlipH {
+		key += "h"
+	}
+	if transform.FlipV {
+		key += "v"
+	}
+	if transform.Invert {
+		key += "i"
+	}
+	return key
+}
+
+// ParseRotation checks that a rotation is a multiple of 90 and returns it in the range 0-270
+func ParseRotation(degrees int) (int, error) {
+	if degrees%90 != 0 {
+		return 0, fmt.Errorf("rotation must be a multiple of 90, got %d", degrees)
+	}
+	return ((degrees % 360) + 360) % 360, nil
+}
+
+// ParseFlip converts a config string into horizontal and vertical flips
+func ParseFlip(value string) (horizontal bool, vertical bool, err error) {
+	switch strings.ToLower(value) {
+	case "", "none":
+		return false, false, nil
+	case "horizontal", "h":
+		return true, false, nil
+	case "vertical", "v":
+		return false, true, nil
+	case "both", "hv":
+		return true, true, nil
+	}
+	return false, false, fmt.Errorf("unknown flip %q", value)
+}
+
+// swapsAxes reports if the image has to be laid out with the width and height swapped
+func (transform ImageTransform) swapsAxes() bool {
+	return transform.Rotate == 90 || transform.Rotate == 270
+}
+
+// apply rotates, flips and inverts a black and white image
+func (transform ImageTransform) apply(src *image.Gray) *image.Gray {
+	if transform.IsIdentity() {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dstW, dstH := transform.size(w, h)
+	dst := image.NewGray(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := transform.position(x, y, w, h)
+			value := src.Pix[src.PixOffset(src.Rect.Min.X+x, src.Rect.Min.Y+y)]
+			if transform.Invert {
+				value = 255 - value
+			}
+			dst.Pix[dy*dst.Stride+dx] = value
+		}
+	}
+	return dst
+}
+
+// applyRGBA rotates, flips and inverts a colour image, inverting keeps the alpha channel
+func (transform ImageTransform) applyRGBA(src *image.RGBA) *image.RGBA {
+	if transform.IsIdentity() {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dstW, dstH := transform.size(w, h)
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := transform.position(x, y, w, h)
+			c := src.RGBAAt(src.Rect.Min.X+x, src.Rect.Min.Y+y)
+			if transform.Invert {
+				c.R, c.G, c.B = c.A-c.R, c.A-c.G, c.A-c.B
+			}
+			dst.SetRGBA(dx, dy, c)
+		}
+	}
+	return dst
+}
+
+// size returns the size of a w by h image after the transform
+func (transform ImageTransform) size(w, h int) (int, int) {
+	if transform.swapsAxes() {
+		return h, w
+	}
+	return w, h
+}
+
+// position returns where the pixel at x, y of a w by h image ends up after the transform
+func (transform ImageTransform) position(x, y, w, h int) (int, int) {
+	dstW, dstH := transform.size(w, h)
+	var dx, dy int
+	switch transform.Rotate {
+	case 90:
+		dx, dy = h-1-y, x
+	case 180:
+		dx, dy = w-1-x, h-1-y
+	case 270:
+		dx, dy = y, w-1-x
+	default:
+		dx, dy = x, y
+	}
+	if transform.FlipH {
+		dx = dstW - 1 - dx
+	}
+	if transform.FlipV {
+		dy = dstH - 1 - dy
+	}
+	return dx, dy
+}
@@ -0,0 +1,129 @@
+package deejdsp
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+)
+
+// TrimMode selects how empty borders are detected before an icon is scaled
+type TrimMode int
+
+// Supported trim modes
+const (
+	TrimOff TrimMode = iota
+	// TrimAlpha removes borders that are fully transparent
+	TrimAlpha
+	// TrimColor removes borders that match the colour of the top left pixel
+	TrimColor
+	// TrimAuto uses TrimAlpha for images with transparency and TrimColor for everything else
+	TrimAuto
+)
+
+// DefaultTrimTolerance is how far (0-255 per channel) a pixel can be from the border colour and still be trimmed
+const DefaultTrimTolerance = 16
+
+// ParseTrimMode converts a config string into a TrimMode
+func ParseTrimMode(value string) (TrimMode, error) {
+	switch strings.ToLower(value) {
+	case "off", "none", "false":
+		return TrimOff, nil
+	case "alpha":
+		return TrimAlpha, nil
+	case "color", "colour", "background":
+		return TrimColor, nil
+	case "auto", "true":
+		return TrimAuto, nil
+	}
+	return TrimOff, fmt.Errorf("unknown trim mode %q", value)
+}
+
+// ContentBounds returns the part of img that is not border
+// tolerance is only used by TrimColor, if nothing is left the full bounds are returned
+func ContentBounds(img image.Image, mode TrimMode, tolerance int) image.Rectangle {
+	bounds := img.Bounds()
+	if mode == TrimOff || bounds.Empty() {
+		return bounds
+	}
+	if mode == TrimAuto {
+		mode = TrimColor
+		if hasTransparency(img) {
+			mode = TrimAlpha
+		}
+	}
+
+	border := color.NRGBAModel.Convert(img.At(bounds.Min.X, bounds.Min.Y)).(color.NRGBA)
+	isContent := func(x, y int) bool {
+		pixel := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+		if mode == TrimAlpha {
+			return pixel.A > 0
+		}
+		return absInt(int(pixel.R)-int(border.R)) > tolerance ||
+			absInt(int(pixel.G)-int(border.G)) > tolerance ||
+			absInt(int(pixel.B)-int(border.B)) > tolerance ||
+			absInt(int(pixel.A)-int(border.A)) > tolerance
+	}
+
+	content := image.Rectangle{Min: bounds.Max, Max: bounds.Min}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !isContent(x, y) {
+				continue
+			}
+			content.Min.X = minInt(content.Min.X, x)
+			content.Min.Y = minInt(content.Min.Y, y)
+			content.Max.X = maxInt(content.Max.X, x+1)
+			content.Max.Y = maxInt(content.Max.Y, y+1)
+		}
+	}
+	if content.Empty() {
+		return bounds
+	}
+	return content
+}
+
+// trimImage crops img down to its content using the trim settings in opts
+func trimImage(img image.Image, opts ConvertOptions) image.Image {
+	content := ContentBounds(img, opts.Trim, opts.TrimTolerance)
+	if content == img.Bounds() {
+		return img
+	}
+	return cropImage(img, content)
+}
+
+// cropImage returns the part of img inside rect
+func cropImage(img image.Image, rect image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+	cropped := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, rect.Min, draw.Src)
+	return cropped
+}
+
+// hasTransparency reports if any pixel in the image is not fully opaque
+func hasTransparency(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return !opaque.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
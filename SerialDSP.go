@@ -2,13 +2,18 @@ package deejdsp
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 
 	"github.com/jax-b/deej/pkg/deej"
+	"github.com/jax-b/deejdsp/bimage"
 	"go.uber.org/zap"
 )
 
+// firmwareTimeout is SERIALTIMEOUT in the firmware, it waits this long for each read before giving up
+const firmwareTimeout = 2000 * time.Millisecond
+
 // SerialDSP stuct for Serial Dispaly Objects
 type SerialDSP struct {
 	sio      *deej.SerialIO
@@ -89,6 +94,208 @@ func (serDSP *SerialDSP) DisplayOff() error {
 
 // SetImage Sends the string of the filename for the image selection
 func (serDSP *SerialDSP) SetImage(filename string) error {
+	return serDSP.SetImageWithLayout(filename, LayoutHorizontal)
+}
+
+// SetImageWithLayout Sends the string of the filename for the image selection
+// LayoutPage writes the image one page at a time for controllers like the SH1106
+func (serDSP *SerialDSP) SetImageWithLayout(filename string, layout PageLayout) error {
+	command := "deej.modules.display.setimage"
+	if layout == LayoutPage {
+		command = "deej.modules.display.setimage.page"
+	}
+	return serDSP.sendFileCommand(command, filename)
+}
+
+// SetCompressedImage Sends the filename of a run length compressed image for the image selection
+func (serDSP *SerialDSP) SetCompressedImage(filename string) error {
+	return serDSP.SetCompressedImageWithLayout(filename, LayoutHorizontal)
+}
+
+// SetCompressedImageWithLayout Sends the filename of a run length compressed image like SetImageWithLayout
+// Only use this if QueryFeatures reports FeatureRLE
+func (serDSP *SerialDSP) SetCompressedImageWithLayout(filename string, layout PageLayout) error {
+	command := "deej.modules.display.setimage.rle"
+	if layout == LayoutPage {
+		command = "deej.modules.display.setimage.rle.page"
+	}
+	return serDSP.sendFileCommand(command, filename)
+}
+
+// PushFramebuffer writes an image straight into the ram of the display on a port of the TCA9548A
+// data is the contents of a .b file for the display, nothing is written to the sd card
+// The port stays selected afterwards, only use this if QueryFeatures reports FeatureFramebuffer
+func (serDSP *SerialDSP) PushFramebuffer(port uint8, data []byte) error {
+	return serDSP.PushFramebufferWithLayout(port, data, LayoutHorizontal)
+}
+
+// PushFramebufferWithLayout writes an image straight into the ram of the display like PushFramebuffer
+// LayoutPage writes the image one page at a time for controllers like the SH1106
+func (serDSP *SerialDSP) PushFramebufferWithLayout(port uint8, data []byte, layout PageLayout) error {
+	if len(data) == 0 {
+		return errors.New("framebuffer is empty")
+	}
+	command := "deej.modules.display.framebuffer"
+	if layout == LayoutPage {
+		command = "deej.modules.display.framebuffer.page"
+	}
+	return serDSP.sendRawCommand(command, fmt.Sprintf("%d %d", port, len(data)), data)
+}
+
+// UpdateRegion writes data into a window of the ram of a default 128x64 display on a port of the TCA9548A
+// data is the bytes inside the region page by page as returned by bimage.ExtractRegion
+// The port stays selected afterwards, only use this if QueryFeatures reports FeatureRegion
+func (serDSP *SerialDSP) UpdateRegion(port uint8, region bimage.Region, data []byte) error {
+	return serDSP.UpdateRegionWithProfile(port, region, data, DefaultDisplayProfile())
+}
+
+// UpdateRegionWithProfile writes data into a window of the display ram like UpdateRegion
+// The region has to fit on the display described by profile, its layout selects the page by page command for the SH1106
+func (serDSP *SerialDSP) UpdateRegionWithProfile(port uint8, region bimage.Region, data []byte, profile DisplayProfile) error {
+	if err := region.Validate(profile.Geometry()); err != nil {
+		return err
+	}
+	if len(data) != region.Size() {
+		return fmt.Errorf("region %s needs %d bytes, got %d", region, region.Size(), len(data))
+	}
+	command := "deej.modules.display.region"
+	if profile.Layout == LayoutPage {
+		command = "deej.modules.display.region.page"
+	}
+	header := fmt.Sprintf("%d %d %d %d %d", port, region.Column, region.Page, region.Width, region.Pages)
+	return serDSP.sendRawCommand(command, header, data)
+}
+
+// PushFrameDiff updates a display from previous to next by only sending the part that changed
+// previous and next are the contents of .b files for the display described by profile
+// A nil previous frame sends the whole of next, nothing is sent if the frames are the same
+func (serDSP *SerialDSP) PushFrameDiff(port uint8, previous, next []byte, profile DisplayProfile) error {
+	if previous == nil {
+		return serDSP.PushFramebufferWithLayout(port, next, profile.Layout)
+	}
+	geometry := profile.Geometry()
+	region, changed, err := bimage.DirtyRegion(previous, next, geometry)
+	if err != nil || !changed {
+		return err
+	}
+	data, err := bimage.ExtractRegion(next, geometry, region)
+	if err != nil {
+		return err
+	}
+	return serDSP.UpdateRegionWithProfile(port, region, data, profile)
+}
+
+// sendRawCommand sends a command, a header line and raw bytes then waits for the arduino to say it is DONE
+func (serDSP *SerialDSP) sendRawCommand(command string, header string, data []byte) error {
+	resumeAfter := serDSP.sio.IsRunning()
+
+	if serDSP.sio.IsRunning() {
+		serDSP.sio.Pause()
+	}
+	if serDSP.siu.ExternalInUse() {
+		c := serDSP.siu.JoinLine()
+		<-c
+		c = nil
+	}
+	serDSP.siu.PreformingTask()
+
+	lineChannel := serDSP.sio.ReadLine(serDSP.logger)
+	serDSP.sio.WriteStringLine(serDSP.logger, command)
+	serDSP.sio.WriteStringLine(serDSP.logger, header)
+	serDSP.sio.WriteBytes(serDSP.logger, data)
+
+	// the firmware can wait firmwareTimeout for the data and again to throw it away after an error
+	var err error
+Loop:
+	for {
+		select {
+		case <-time.After(2*firmwareTimeout + time.Second):
+			err = errors.New("TIMEOUT")
+			break Loop
+		case msg := <-lineChannel:
+			msg = strings.TrimSuffix(msg, "\r\n")
+			switch msg {
+			case "DONE":
+				break Loop
+			case "INVALIDCOMMAND":
+				err = errors.New(msg)
+				break Loop
+			case "TIMEOUT", "BADLENGTH", "BADREGION":
+				// the firmware still says DONE once it has read the rest of the data
+				err = errors.New(msg)
+			case "":
+			default:
+				serDSP.logger.Info(msg)
+			}
+		}
+	}
+	lineChannel = nil
+
+	if serDSP.cmddelay > (time.Microsecond * 1) {
+		time.Sleep(serDSP.cmddelay)
+	}
+
+	if resumeAfter {
+		serDSP.sio.Start()
+	}
+	serDSP.siu.Done()
+	return err
+}
+
+// PlayAnimation starts playing an animation file from the sd card on the selected display
+// The animation loops until StopAnimation or SetImage is called for the display
+func (serDSP *SerialDSP) PlayAnimation(filename string) error {
+	return serDSP.PlayAnimationWithLayout(filename, LayoutHorizontal)
+}
+
+// PlayAnimationWithLayout starts playing an animation like PlayAnimation
+// LayoutPage writes every frame one page at a time for controllers like the SH1106
+func (serDSP *SerialDSP) PlayAnimationWithLayout(filename string, layout PageLayout) error {
+	command := "deej.modules.display.playanimation"
+	if layout == LayoutPage {
+		command = "deej.modules.display.playanimation.page"
+	}
+	return serDSP.sendFileCommand(command, filename)
+}
+
+// StopAnimation stops the animation on the selected display, the current frame stays on the display
+func (serDSP *SerialDSP) StopAnimation() error {
+	resumeAfter := serDSP.sio.IsRunning()
+
+	if serDSP.sio.IsRunning() {
+		serDSP.sio.Pause()
+	}
+	if serDSP.siu.ExternalInUse() {
+		c := serDSP.siu.JoinLine()
+		<-c
+		c = nil
+	}
+	serDSP.siu.PreformingTask()
+
+	serDSP.sio.WriteStringLine(serDSP.logger, "deej.modules.display.stopanimation")
+
+	if serDSP.cmddelay > (time.Microsecond * 1) {
+		time.Sleep(serDSP.cmddelay)
+	}
+
+	if resumeAfter {
+		serDSP.sio.Start()
+	}
+	serDSP.siu.Done()
+	return nil
+}
+
+// fileCommandErrors are the replies the firmware sends before DONE when it can not use a file
+var fileCommandErrors = map[string]bool{
+	"FILENOTFOUND":    true,
+	"NOTCOMPRESSED":   true,
+	"WRONGSIZE":       true,
+	"FILENAMETOOLONG": true,
+	"NOTANIMATION":    true,
+}
+
+// sendFileCommand sends a command followed by a filename and waits for the arduino to say it is DONE
+func (serDSP *SerialDSP) sendFileCommand(command string, filename string) error {
 	resumeAfter := serDSP.sio.IsRunning()
 
 	if serDSP.sio.IsRunning() {
@@ -108,33 +315,33 @@ func (serDSP *SerialDSP) SetImage(filename string) error {
 	case <-lineChannel:
 		break
 	}
-	serDSP.sio.WriteStringLine(serDSP.logger, "deej.modules.display.setimage")
+	serDSP.sio.WriteStringLine(serDSP.logger, command)
 	time.Sleep(5 * time.Millisecond)
 	serDSP.sio.WriteStringLine(serDSP.logger, filename)
 
+	var err error
 Loop:
 	for {
 		select {
 		case <-time.After(350 * time.Millisecond):
-			lineChannel = nil
-
-			if resumeAfter {
-				serDSP.sio.Start()
-			}
-
-			if serDSP.cmddelay > (time.Microsecond * 1) {
-				serDSP.logger.Infof("Sleeping for cmd: %s milliseconds", serDSP.cmddelay)
-				time.Sleep(serDSP.cmddelay)
-			}
-
-			return errors.New("TIMEOUT")
+			// fall through to the clean up so the serial port is released for the next command
+			err = errors.New("TIMEOUT")
+			break Loop
 		case msg := <-lineChannel:
 			msg = strings.TrimSuffix(msg, "\r\n")
 			if msg == "DONE" {
 				break Loop
-			} else {
-				serDSP.logger.Info(msg)
 			}
+			// older firmware prints some errors without a newline so they end up in front of DONE
+			reply := strings.TrimSuffix(msg, "DONE")
+			if fileCommandErrors[reply] {
+				err = fmt.Errorf("%s: %s", filename, reply)
+				if reply != msg {
+					break Loop
+				}
+				continue
+			}
+			serDSP.logger.Info(msg)
 		}
 	}
 
@@ -145,9 +352,111 @@ Loop:
 		time.Sleep(serDSP.cmddelay)
 	}
 
+	if resumeAfter {
+		serDSP.sio.Start()
+	}
+	serDSP.siu.Done()
+	return err
+}
+
+// SetGeometry tells the selected display what size panel is connected to it
+// This only needs to be sent for displays that are not the default 128x64
+func (serDSP *SerialDSP) SetGeometry(profile DisplayProfile) error {
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+	resumeAfter := serDSP.sio.IsRunning()
+
+	if serDSP.sio.IsRunning() {
+		serDSP.sio.Pause()
+	}
+	if serDSP.siu.ExternalInUse() {
+		c := serDSP.siu.JoinLine()
+		<-c
+		c = nil
+	}
+	serDSP.siu.PreformingTask()
+
+	serDSP.sio.WriteStringLine(serDSP.logger, "deej.modules.display.geometry")
+	serDSP.sio.WriteStringLine(serDSP.logger, fmt.Sprintf("%d %d %d", profile.Width, profile.Height, profile.ColumnOffset))
+
+	if serDSP.cmddelay > (time.Microsecond * 1) {
+		time.Sleep(serDSP.cmddelay)
+	}
+
 	if resumeAfter {
 		serDSP.sio.Start()
 	}
 	serDSP.siu.Done()
 	return nil
 }
+
+// Features is the set of optional commands the firmware supports
+type Features map[string]bool
+
+// Optional firmware features
+const (
+	FeatureGeometry    = "geometry"
+	FeaturePageMode    = "page"
+	FeatureAnimation   = "animation"
+	FeatureRLE         = "rle"
+	FeatureFramebuffer = "framebuffer"
+	FeatureRegion      = "region"
+)
+
+// Has reports if the firmware supports a feature
+func (features Features) Has(name string) bool {
+	return features[strings.ToLower(name)]
+}
+
+// QueryFeatures asks the firmware which optional commands it supports
+// Firmware from before the features command returns an empty set
+func (serDSP *SerialDSP) QueryFeatures() (Features, error) {
+	resumeAfter := serDSP.sio.IsRunning()
+
+	if serDSP.sio.IsRunning() {
+		serDSP.sio.Pause()
+	}
+	if serDSP.siu.ExternalInUse() {
+		c := serDSP.siu.JoinLine()
+		<-c
+		c = nil
+	}
+	serDSP.siu.PreformingTask()
+
+	features := Features{}
+	lineChannel := serDSP.sio.ReadLine(serDSP.logger)
+	serDSP.sio.WriteStringLine(serDSP.logger, "deej.core.features")
+
+	var err error
+Loop:
+	for {
+		select {
+		case <-time.After(350 * time.Millisecond):
+			err = errors.New("TIMEOUT")
+			break Loop
+		case msg := <-lineChannel:
+			msg = strings.TrimSuffix(msg, "\r\n")
+			switch {
+			case msg == "INVALIDCOMMAND":
+				break Loop
+			case strings.HasPrefix(msg, "FEATURES"):
+				for _, name := range strings.Fields(strings.TrimPrefix(msg, "FEATURES")) {
+					features[strings.ToLower(name)] = true
+				}
+				break Loop
+			}
+		}
+	}
+	lineChannel = nil
+
+	if serDSP.cmddelay > (time.Microsecond * 1) {
+		time.Sleep(serDSP.cmddelay)
+	}
+
+	if resumeAfter {
+		serDSP.sio.Start()
+	}
+	serDSP.siu.Done()
+	return features, err
+}
@@ -2,7 +2,9 @@ package deejdsp
 
 import (
 	"bufio"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strings"
@@ -296,3 +298,69 @@ Loop:
 	serSD.siu.Done()
 	return nil
 }
+
+// ReadFile reads a file back off of the SD card
+// The arduino sends the file as lines of hex followed by DONE
+func (serSD *SerialSD) ReadFile(filename string) ([]byte, error) {
+	resumeAfter := serSD.sio.IsRunning()
+	if serSD.sio.IsRunning() {
+		serSD.sio.Pause()
+	}
+	if serSD.siu.ExternalInUse() {
+		c := serSD.siu.JoinLine()
+		<-c
+		c = nil
+	}
+	serSD.siu.PreformingTask()
+	serSD.sio.Flush(serSD.logger)
+
+	var contents []byte
+	var readErr error
+
+	lineChannel := serSD.sio.ReadLine(serSD.logger)
+
+	time.Sleep(10 * time.Millisecond)
+
+	serSD.logger.Debugf("Reading %q from the SD Card", filename)
+	serSD.sio.WriteStringLine(serSD.logger, "deej.modules.sd.read")
+	serSD.sio.WriteStringLine(serSD.logger, filename)
+
+Loop:
+	for {
+		select {
+		case <-time.After(2 * time.Second):
+			readErr = errors.New("Timeout (waiting for arduino)")
+			break Loop
+		case msg := <-lineChannel:
+			msg = strings.TrimSpace(msg)
+			if msg == "DONE" {
+				break Loop
+			} else if msg == "" || msg == "__IGNORE_ME__" {
+			} else if msg == "FILENOTFOUND" || msg == "TIMEOUT" {
+				readErr = fmt.Errorf("read %s: %s", filename, msg)
+			} else {
+				line, err := hex.DecodeString(msg)
+				if err != nil {
+					readErr = fmt.Errorf("read %s: bad line from arduino: %w", filename, err)
+					continue
+				}
+				contents = append(contents, line...)
+			}
+		}
+	}
+
+	lineChannel = nil
+	serSD.sio.Flush(serSD.logger)
+	if serSD.cmddelay > (time.Microsecond * 1) {
+		time.Sleep(serSD.cmddelay)
+	}
+
+	if resumeAfter {
+		serSD.sio.Start()
+	}
+	serSD.siu.Done()
+	if readErr != nil {
+		return nil, readErr
+	}
+	return contents, nil
+}
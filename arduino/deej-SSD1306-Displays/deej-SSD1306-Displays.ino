@@ -30,6 +30,22 @@ const uint8_t analogInputs[NUM_SLIDERS] = {A0,A1,A2,A3,A6,A7};
 
 uint16_t analogSliderValues[NUM_SLIDERS];
 
+// Size of the panel on each display port, set with deej.modules.display.geometry
+uint8_t dspWidth[NUM_DISPLAYS];
+uint8_t dspHeight[NUM_DISPLAYS];
+uint8_t dspColumnOffset[NUM_DISPLAYS];
+// Port last selected on the TCA9548A
+uint8_t currentPort = 0;
+
+// Animation playing on each display port, started with deej.modules.display.playanimation
+// Animation files start with "BA", the width, height and 2 bytes of frame count
+#define ANIMATIONHEADER 6
+char animFile[NUM_DISPLAYS][13];
+bool animPlaying[NUM_DISPLAYS];
+bool animPageMode[NUM_DISPLAYS];
+uint32_t animPosition[NUM_DISPLAYS];
+unsigned long animNextFrame[NUM_DISPLAYS];
+
 // Detect Host System Sleep
 unsigned long lastcommand;
 bool sysSleep;
@@ -39,6 +55,14 @@ bool pushSliderValuesToPC = false;
 
 SdFat sd;
 
+// State of a run length compressed file being read by rleRead
+struct RLEReader {
+  File *file;
+  int16_t literalLeft;
+  int16_t repeatLeft;
+  int16_t value;
+};
+
 String outboundCommands = "";
 
 void setup() { 
@@ -61,6 +85,10 @@ void setup() {
   
   for (int i = 0; i < NUM_DISPLAYS; i++) {
     Serial.print("DSP" + String(i) + "INIT ");
+    dspWidth[i] = SCREEN_WIDTH;
+    dspHeight[i] = SCREEN_HEIGHT;
+    dspColumnOffset[i] = 0;
+    animPlaying[i] = false;
     tcaselect(IICMULTIPLEXADDR, i);
     dspInit(IICDSPADDR);
     dspClear(IICDSPADDR);
@@ -88,6 +116,11 @@ void loop() {
     sendSliderValues(); // Actually send data
   }
 
+  // the displays are off while the host is asleep so there is no point drawing frames
+  if (!sysSleep) {
+    updateAnimations();
+  }
+
   if (millis() - lastcommand >= SLEEPDETECTION) {
     for (int i = 0; i < NUM_DISPLAYS; i++) {
       tcaselect(IICMULTIPLEXADDR, i);
@@ -209,6 +242,11 @@ void checkForCommand() {
         reboot();
       }
 
+      // List the optional commands this firmware supports so deej knows what it can use
+      else if ( input.equalsIgnoreCase("deej.core.features") == true ) {
+        Serial.println("FEATURES geometry page animation rle framebuffer region");
+      }
+
       // Select Port on TCA9548A
       // Following this command send the port number on a new line
       else if ( input.equalsIgnoreCase("deej.modules.TCA9548A.select")== true) {
@@ -241,6 +279,7 @@ void checkForCommand() {
             Serial.print("FILENOTFOUND");
           }
           else {
+            stopAnimation(currentPort);
             dspSetImage(IICDSPADDR,filename);
           }
         }
@@ -249,6 +288,201 @@ void checkForCommand() {
         Serial.println("DONE");
       }
 
+      // Write an image straight into the display ram without using the sd card
+      // Following this command send the port and the number of bytes on a new line separated by a space
+      // then the raw bytes of the image in the same layout as a .b file
+      // The .page version writes the image one page at a time for the SH1106
+      else if ( input.equalsIgnoreCase("deej.modules.display.framebuffer") == true || input.equalsIgnoreCase("deej.modules.display.framebuffer.page") == true ){
+        timeStart = millis();
+        bool pageMode = input.equalsIgnoreCase("deej.modules.display.framebuffer.page");
+
+        //Get data from Serial
+        uint8_t portnumber = Serial.parseInt();
+        uint16_t length = Serial.parseInt();
+        Serial.readStringUntil('\n');
+
+        if(millis()-timeStart >= SERIALTIMEOUT) {
+          // the image is still coming so throw it away instead of reading it as commands
+          Serial.println("TIMEOUT");
+          serialDrainUntilIdle();
+        }
+        else {
+          tcaselect(IICMULTIPLEXADDR, portnumber);
+          uint8_t width = SCREEN_WIDTH;
+          uint8_t height = SCREEN_HEIGHT;
+          if (currentPort < NUM_DISPLAYS) {
+            width = dspWidth[currentPort];
+            height = dspHeight[currentPort];
+          }
+          // the image has to cover the whole display
+          if (length != (uint16_t)width * (height / 8)) {
+            Serial.println("BADLENGTH");
+            serialDrain(length);
+          }
+          else {
+            stopAnimation(currentPort);
+            if (!dspWriteSerialRegion(IICDSPADDR, 0, 0, width, length, pageMode)) {
+              Serial.println("TIMEOUT");
+            }
+          }
+        }
+        // Any Time intensive calls should be monitored by deej
+        // Will waitfor DONE
+        Serial.println("DONE");
+      }
+
+      // Write part of an image straight into the display ram
+      // Following this command send the port, first column, first page, width in columns and number of pages
+      // on a new line separated by spaces then width * pages raw bytes page by page
+      // The .page version writes the region one page at a time for the SH1106
+      else if ( input.equalsIgnoreCase("deej.modules.display.region") == true || input.equalsIgnoreCase("deej.modules.display.region.page") == true ){
+        timeStart = millis();
+        bool pageMode = input.equalsIgnoreCase("deej.modules.display.region.page");
+
+        //Get data from Serial
+        uint8_t portnumber = Serial.parseInt();
+        uint8_t column = Serial.parseInt();
+        uint8_t page = Serial.parseInt();
+        uint8_t width = Serial.parseInt();
+        uint8_t pages = Serial.parseInt();
+        Serial.readStringUntil('\n');
+        uint16_t length = (uint16_t)width * pages;
+
+        if(millis()-timeStart >= SERIALTIMEOUT) {
+          // the region is still coming so throw it away instead of reading it as commands
+          Serial.println("TIMEOUT");
+          serialDrainUntilIdle();
+        }
+        else {
+          tcaselect(IICMULTIPLEXADDR, portnumber);
+          uint8_t displayWidth = SCREEN_WIDTH;
+          uint8_t displayPages = SCREEN_HEIGHT / 8;
+          if (currentPort < NUM_DISPLAYS) {
+            displayWidth = dspWidth[currentPort];
+            displayPages = dspHeight[currentPort] / 8;
+          }
+          // the window has to be on the display, the controllers wrap writes outside it onto the other side
+          if (length == 0 || (uint16_t)column + width > displayWidth || page >= 8 || (uint16_t)page + pages > displayPages) {
+            Serial.println("BADREGION");
+            serialDrain(length);
+          }
+          else {
+            stopAnimation(currentPort);
+            if (!dspWriteSerialRegion(IICDSPADDR, column, page, width, length, pageMode)) {
+              Serial.println("TIMEOUT");
+            }
+          }
+        }
+        // Any Time intensive calls should be monitored by deej
+        // Will waitfor DONE
+        Serial.println("DONE");
+      }
+
+      // Set image on display from a run length compressed file
+      // Following this command send the file name on a new line
+      // The .page version writes the image one page at a time for the SH1106
+      else if ( input.equalsIgnoreCase("deej.modules.display.setimage.rle") == true || input.equalsIgnoreCase("deej.modules.display.setimage.rle.page") == true ){
+        timeStart = millis();
+        bool pageMode = input.equalsIgnoreCase("deej.modules.display.setimage.rle.page");
+
+        //Get data from Serial
+        String filename = Serial.readStringUntil('\n');  // Read chars from Serial monitor
+        
+        if(millis()-timeStart >= SERIALTIMEOUT) {
+          Serial.println("TIMEOUT");
+        }
+        else {
+          if (!sd.exists(filename.c_str())){
+            Serial.println("FILENOTFOUND");
+          }
+          else {
+            stopAnimation(currentPort);
+            dspSetImageRLE(IICDSPADDR, filename, pageMode);
+          }
+        }
+        // Any Time intensive calls should be monitored by deej
+        // Will waitfor DONE
+        Serial.println("DONE");
+      }
+
+      // Set image on display from file one page at a time
+      // Used by controllers without horizontal addressing like the SH1106
+      else if ( input.equalsIgnoreCase("deej.modules.display.setimage.page") == true ){
+        timeStart = millis();
+
+        //Get data from Serial
+        String filename = Serial.readStringUntil('\n');  // Read chars from Serial monitor
+        
+        if(millis()-timeStart >= SERIALTIMEOUT) {
+          Serial.println("TIMEOUT");
+        }
+        else {
+          if (!sd.exists(filename.c_str())){
+            Serial.println("FILENOTFOUND");
+          }
+          else {
+            stopAnimation(currentPort);
+            dspSetImagePage(IICDSPADDR,filename);
+          }
+        }
+        // Any Time intensive calls should be monitored by deej
+        // Will waitfor DONE
+        Serial.println("DONE");
+      }
+
+      // Play an animation file on the selected display
+      // Following this command send the file name on a new line
+      // The .page version writes each frame one page at a time for the SH1106
+      else if ( input.equalsIgnoreCase("deej.modules.display.playanimation") == true || input.equalsIgnoreCase("deej.modules.display.playanimation.page") == true ){
+        timeStart = millis();
+        bool pageMode = input.equalsIgnoreCase("deej.modules.display.playanimation.page");
+
+        //Get data from Serial
+        String filename = Serial.readStringUntil('\n');  // Read chars from Serial monitor
+        
+        if(millis()-timeStart >= SERIALTIMEOUT) {
+          Serial.println("TIMEOUT");
+        }
+        else {
+          if (!sd.exists(filename.c_str())){
+            Serial.println("FILENOTFOUND");
+          }
+          else {
+            startAnimation(currentPort, filename, pageMode);
+          }
+        }
+        // Any Time intensive calls should be monitored by deej
+        // Will waitfor DONE
+        Serial.println("DONE");
+      }
+
+      // Stop the animation on the selected display, the last frame is left on the display
+      else if ( input.equalsIgnoreCase("deej.modules.display.stopanimation") == true ){
+        stopAnimation(currentPort);
+      }
+
+      // Set the size of the panel on the selected display
+      // Following this command send the width, height and column offset on a new line separated by spaces
+      else if ( input.equalsIgnoreCase("deej.modules.display.geometry") == true ){
+        timeStart = millis();
+
+        //Get data from Serial
+        uint8_t width = Serial.parseInt();
+        uint8_t height = Serial.parseInt();
+        uint8_t columnOffset = Serial.parseInt();
+        Serial.readStringUntil('\n');
+
+        if(millis()-timeStart >= SERIALTIMEOUT) {
+          Serial.println("TIMEOUT");
+        }
+        else if (currentPort < NUM_DISPLAYS && width > 0 && height > 0 && height % 8 == 0) {
+          dspWidth[currentPort] = width;
+          dspHeight[currentPort] = height;
+          dspColumnOffset[currentPort] = columnOffset;
+          dspApplyGeometry(IICDSPADDR);
+        }
+      }
+
       // Turn a display off
       // Image is keept in the displays ram so no need to resend the image
       else if ( input.equalsIgnoreCase("deej.modules.display.off") == true) {
@@ -294,6 +528,26 @@ void checkForCommand() {
         Serial.println("DONE");
       }
 
+      // Read a file off of the sd card
+      // Following this command send the file name on a new line
+      // The file is sent back as hex with 32 bytes on each line
+      else if ( input.equalsIgnoreCase("deej.modules.sd.read") == true){
+        timeStart = millis();
+
+        //Get data from Serial
+        String filename = Serial.readStringUntil('\n');  // Read chars from Serial monitor
+
+        if(millis()-timeStart >= SERIALTIMEOUT) {
+          Serial.println("TIMEOUT");
+        }
+        else {
+          sdReadFile(filename);
+        }
+        // Any Time intensive calls should be monitored by deej
+        // Will waitfor DONE
+        Serial.println("DONE");
+      }
+
       // delete a file on the sd card
       // Following this command send the file name on a new line
       else if ( input.equalsIgnoreCase("deej.modules.sd.delete") == true){
@@ -381,6 +635,35 @@ void sdPutFile(const String filename) {
     Serial.println("EOFDETECT"); 
 }
 
+// SD Card read file
+// Sends the file as hex so it can be read line by line
+void sdReadFile(const String filename) {
+  if (!sd.exists(filename.c_str())){
+    Serial.println("FILENOTFOUND");
+    return;
+  }
+
+  File readFile = sd.open(filename, O_READ);
+  uint8_t bytesInLine = 0;
+  int16_t inputChar = readFile.read();
+  while (inputChar != -1) {
+    if (inputChar < 0x10) {
+      Serial.print('0');
+    }
+    Serial.print(inputChar, HEX);
+    bytesInLine++;
+    if (bytesInLine == 32) {
+      Serial.println();
+      bytesInLine = 0;
+    }
+    inputChar = readFile.read();
+  }
+  if (bytesInLine != 0) {
+    Serial.println();
+  }
+  readFile.close();
+}
+
 // SD Card delete file
 void sdDelete(const String filename) {
   char charbuff[filename.length()+1];
@@ -403,6 +686,7 @@ void tcaselect(uint8_t addr, uint8_t i) {
   Wire.beginTransmission(addr);
   Wire.write(1 << i);
   Wire.endTransmission();  
+  currentPort = i;
 }
 
 // Display Module Start
@@ -469,6 +753,21 @@ void dspInit(uint8_t addr){
   }
 }
 
+// changes the multiplex ratio and com pins to match the size of the panel on the selected port
+void dspApplyGeometry(uint8_t addr) {
+  if (currentPort >= NUM_DISPLAYS) return;
+
+  dspSendCommand(addr, OLED_SETMULTIPLEX);
+  dspSendCommand(addr, dspHeight[currentPort] - 1);
+  dspSendCommand(addr, OLED_SETCOMPINS);
+  // panels of 32 rows or less use sequential com pins
+  if (dspHeight[currentPort] > 32) {
+    dspSendCommand(addr, 0x12);
+  } else {
+    dspSendCommand(addr, 0x02);
+  }
+}
+
 // set the column 
 // ref the ssd 1306 datasheet if you want to find out how it works
 void dspSetColumn(uint8_t addr, uint8_t cstart, uint8_t cend) {
@@ -510,35 +809,276 @@ void dspClear(uint8_t addr){
 // Writes a image to the ssd1306 display
 void dspSetImage(uint8_t addr, String imagefilename) {
   // open the image file
-  // also this file should almost allways contain 8192 bytes
+  // also this file should contain width * height / 8 bytes
   File imgFile = sd.open(imagefilename, O_READ);
   
   // clear the display not needed as it will get replaced anyways
   // dspClear(addr);
 
+  dspWriteFrame(addr, imgFile, false, false);
+  imgFile.close();
+}
+
+// Writes a image to the display using page addressing
+// The SH1106 does not have horizontal addressing so the page and column have to be set before each page
+void dspSetImagePage(uint8_t addr, String imagefilename) {
+  File imgFile = sd.open(imagefilename, O_READ);
+  dspWriteFrame(addr, imgFile, true, false);
+  imgFile.close();
+}
+
+// Writes a run length compressed image to the display
+// The file starts with "BR" and the width and height which have to match the display
+void dspSetImageRLE(uint8_t addr, String imagefilename, bool pageMode) {
+  File imgFile = sd.open(imagefilename, O_READ);
+
+  char magic[2];
+  magic[0] = imgFile.read();
+  magic[1] = imgFile.read();
+  uint8_t width = imgFile.read();
+  uint8_t height = imgFile.read();
+
+  if (magic[0] != 'B' || magic[1] != 'R') {
+    Serial.println("NOTCOMPRESSED");
+  }
+  else if (currentPort < NUM_DISPLAYS && (width != dspWidth[currentPort] || height != dspHeight[currentPort])) {
+    Serial.println("WRONGSIZE");
+  }
+  else {
+    dspWriteFrame(addr, imgFile, pageMode, true);
+  }
+  imgFile.close();
+}
+
+// Expands PackBits run length encoding while a file is read
+// 0-127 copies the next control+1 bytes, 129-255 repeats the next byte 257-control times and 128 is skipped
+int16_t rleRead(RLEReader &reader) {
+  while (true) {
+    if (reader.repeatLeft > 0) {
+      reader.repeatLeft--;
+      return reader.value;
+    }
+    if (reader.literalLeft > 0) {
+      reader.literalLeft--;
+      return reader.file->read();
+    }
+
+    int16_t control = reader.file->read();
+    if (control == -1) {
+      return -1;
+    }
+    if (control < 128) {
+      reader.literalLeft = control + 1;
+    }
+    else if (control > 128) {
+      reader.value = reader.file->read();
+      if (reader.value == -1) {
+        return -1;
+      }
+      reader.repeatLeft = 257 - control;
+    }
+  }
+}
+
+// Writes one image from the current position of a file to the selected display
+// compressed expands the file with rleRead as it is written
+void dspWriteFrame(uint8_t addr, File &imgFile, bool pageMode, bool compressed) {
+  RLEReader reader = {&imgFile, 0, 0, 0};
+
   // initialize some temp vars
   int16_t inputChar = 0;
-  int maxPages = 8;
+  uint8_t width = SCREEN_WIDTH;
+  uint8_t columnOffset = 0;
+  int maxPages = SCREEN_HEIGHT / 8;
+  if (currentPort < NUM_DISPLAYS) {
+    width = dspWidth[currentPort];
+    columnOffset = dspColumnOffset[currentPort];
+    maxPages = dspHeight[currentPort] / 8;
+  }
+
+  if (!pageMode) {
+    // only write to the part of the ram that is visible on the panel
+    dspSetColumn(addr, columnOffset, columnOffset + width - 1);
+    dspSetPage(addr, 0, maxPages - 1);
+  }
 
   // loop through each page 
   // each padge is 8 Vertical bytes per column
-  // we write to 128 columns each column is 8 bytes tall or 8 pixel.
-  // there are 8 pages [0-7] to make up the 64 pixel tall display
+  // we write to each column, each column is 8 bytes tall or 8 pixel.
+  // there are 8 pages [0-7] to make up a 64 pixel tall display
   // we also process all posable ascii char including newline and carrage return
   // since a char is one byte it makes it easy to read data from the file and into the buffer
-  while (maxPages != 0 && inputChar != -1){
-    int CharsLeftInLine = 128;
-    while  (CharsLeftInLine > 0 && inputChar != -1){
-      inputChar = imgFile.read();
-      // Serial.print(char(inputChar));
+  for (int page = 0; page < maxPages && inputChar != -1; page++){
+    if (pageMode) {
+      // move to the start of the visible part of the page
+      dspSendCommand(addr, OLED_SETPAGESTART | page);
+      dspSendCommand(addr, OLED_SETLOWCOLUMN | (columnOffset & 0x0F));
+      dspSendCommand(addr, OLED_SETHIGHCOLUMN | (columnOffset >> 4));
+    }
+
+    int CharsLeftInLine = width;
+    while  (CharsLeftInLine > 0){
+      if (compressed) {
+        inputChar = rleRead(reader);
+      } else {
+        inputChar = imgFile.read();
+      }
       if(inputChar == -1){
         break;
       }
       dspSendData(addr, inputChar);
       CharsLeftInLine--;
     }
-    // Serial.println();
-    maxPages--;
   }
-  imgFile.close();
+}
+
+// Writes length bytes from serial into a window of the selected display
+// The window starts at column and page and is width columns wide, it ends after length bytes
+// Returns false if serial stops sending before the window is complete
+bool dspWriteSerialRegion(uint8_t addr, uint8_t column, uint8_t page, uint8_t width, uint16_t length, bool pageMode) {
+  uint8_t columnOffset = 0;
+  uint8_t maxPages = SCREEN_HEIGHT / 8;
+  if (currentPort < NUM_DISPLAYS) {
+    columnOffset = dspColumnOffset[currentPort];
+    maxPages = dspHeight[currentPort] / 8;
+  }
+  if (width == 0) {
+    return true;
+  }
+  uint8_t pages = (length + width - 1) / width;
+  if (page + pages > maxPages) {
+    pages = maxPages - page;
+  }
+  uint8_t startColumn = columnOffset + column;
+
+  if (!pageMode) {
+    // the column and page address commands limit writes to the window and wrap at its edges
+    dspSetColumn(addr, startColumn, startColumn + width - 1);
+    dspSetPage(addr, page, page + pages - 1);
+  }
+
+  uint8_t buffer[32];
+  uint8_t x = 0;
+  uint8_t currentPage = page;
+  while (length > 0) {
+    size_t chunk = min((uint16_t)sizeof(buffer), length);
+    size_t received = Serial.readBytes(buffer, chunk);
+    if (received == 0) {
+      return false;
+    }
+    for (size_t i = 0; i < received; i++) {
+      if (pageMode && x == 0) {
+        // move to the start of the window on this page
+        dspSendCommand(addr, OLED_SETPAGESTART | currentPage);
+        dspSendCommand(addr, OLED_SETLOWCOLUMN | (startColumn & 0x0F));
+        dspSendCommand(addr, OLED_SETHIGHCOLUMN | (startColumn >> 4));
+      }
+      dspSendData(addr, buffer[i]);
+      x++;
+      if (x >= width) {
+        x = 0;
+        currentPage++;
+      }
+    }
+    length -= received;
+  }
+  return true;
+}
+
+// Reads and throws away length bytes from serial so raw image data is not read as commands
+void serialDrain(uint16_t length) {
+  uint8_t buffer[32];
+  while (length > 0) {
+    size_t received = Serial.readBytes(buffer, min((uint16_t)sizeof(buffer), length));
+    if (received == 0) {
+      return;
+    }
+    length -= received;
+  }
+}
+
+// Throws away everything on serial until nothing has been sent for SERIALTIMEOUT
+// Used when the number of raw bytes that follow is not known
+void serialDrainUntilIdle() {
+  uint8_t buffer[32];
+  while (Serial.readBytes(buffer, sizeof(buffer)) > 0) {
+  }
+}
+
+// Starts an animation on a display port
+// The animation has to be the same size as the display
+void startAnimation(uint8_t port, String filename, bool pageMode) {
+  if (port >= NUM_DISPLAYS) return;
+  if (filename.length() >= sizeof(animFile[port])) {
+    Serial.println("FILENAMETOOLONG");
+    return;
+  }
+
+  File animation = sd.open(filename, O_READ);
+  char magic[2];
+  magic[0] = animation.read();
+  magic[1] = animation.read();
+  uint8_t width = animation.read();
+  uint8_t height = animation.read();
+  animation.close();
+
+  if (magic[0] != 'B' || magic[1] != 'A') {
+    Serial.println("NOTANIMATION");
+    return;
+  }
+  if (width != dspWidth[port] || height != dspHeight[port]) {
+    Serial.println("WRONGSIZE");
+    return;
+  }
+
+  filename.toCharArray(animFile[port], sizeof(animFile[port]));
+  animPageMode[port] = pageMode;
+  animPosition[port] = ANIMATIONHEADER;
+  animNextFrame[port] = millis();
+  animPlaying[port] = true;
+}
+
+// Stops the animation on a display port
+void stopAnimation(uint8_t port) {
+  if (port >= NUM_DISPLAYS) return;
+  animPlaying[port] = false;
+}
+
+// Draws the next frame of every animation that is due
+// Each frame is a 2 byte delay in milliseconds followed by the frame in the same layout as a .b file
+void updateAnimations() {
+  uint8_t selectedPort = currentPort;
+  bool changedPort = false;
+
+  for (uint8_t i = 0; i < NUM_DISPLAYS; i++) {
+    if (!animPlaying[i] || (long)(millis() - animNextFrame[i]) < 0) {
+      continue;
+    }
+
+    File animation = sd.open(animFile[i], O_READ);
+    if (!animation) {
+      animPlaying[i] = false;
+      continue;
+    }
+    // go back to the first frame at the end of the file
+    if (animPosition[i] >= animation.fileSize()) {
+      animPosition[i] = ANIMATIONHEADER;
+    }
+    animation.seekSet(animPosition[i]);
+    uint16_t frameDelay = animation.read() << 8;
+    frameDelay |= animation.read();
+
+    tcaselect(IICMULTIPLEXADDR, i);
+    changedPort = true;
+    dspWriteFrame(IICDSPADDR, animation, animPageMode[i], false);
+
+    animPosition[i] = animation.curPosition();
+    animation.close();
+    animNextFrame[i] = millis() + frameDelay;
+  }
+
+  // put the multiplexer back so the next command goes to the display deej selected
+  if (changedPort) {
+    tcaselect(IICMULTIPLEXADDR, selectedPort);
+  }
 }
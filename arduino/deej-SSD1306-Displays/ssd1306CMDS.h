@@ -49,6 +49,10 @@
 // 0-7 page start address
 // 0-7 page end Address
 // 0xB0 -0xB7 ..... Pick page 0-7
+// Only used for page address mode, this is the only mode on the SH1106
+#define OLED_SETPAGESTART                             0xB0
+#define OLED_SETLOWCOLUMN                             0x00
+#define OLED_SETHIGHCOLUMN                            0x10
 ////////////////////////////////////////////////////////////////////////
 // Fundamental Command Table Page 28
 ////////////////////////////////////////////////////////////////////////
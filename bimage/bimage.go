@@ -0,0 +1,276 @@
+// Package bimage reads and writes the .b image files shown on the ssd1306 displays
+//
+// A .b file is the raw graphics ram of the display. It is split into pages of 8 rows,
+// each page holds one byte per column with the top row in the lowest bit.
+// The pages are stored one after another starting at the top of the display.
+//
+// Importing this package registers the format with image.Decode.
+// The format has no header so it is registered as a fallback that only accepts files the size of a known display.
+// It matches every file, formats registered after it are not found by image.Decode so decode those directly.
+package bimage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+
+	// registered first so their magic numbers are checked before the .b fallback
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Geometry of the 128x64 ssd1306 display, this is the default for all functions that are not given a Geometry
+const (
+	Width    = 128
+	Height   = 64
+	PageRows = 8
+	Pages    = Height / PageRows
+	Size     = Width * Pages
+)
+
+// Geometry is the size in pixels of a display, Height has to be a multiple of PageRows
+type Geometry struct {
+	Width  int
+	Height int
+}
+
+// Default is the geometry of the 128x64 ssd1306 display
+var Default = Geometry{Width: Width, Height: Height}
+
+// KnownGeometries are the panel sizes that can be detected from the size of a file
+// Sizes that are shared by more than one panel use the first one in the list
+var KnownGeometries = []Geometry{
+	Default,
+	{Width: 128, Height: 32},
+	{Width: 96, Height: 16},
+	{Width: 64, Height: 48},
+	{Width: 64, Height: 32},
+	{Width: 72, Height: 40},
+}
+
+// Pages returns the number of 8 row pages on the display
+func (g Geometry) Pages() int {
+	return g.Height / PageRows
+}
+
+// Size returns the number of bytes in a .b file for the display
+func (g Geometry) Size() int {
+	return g.Width * g.Pages()
+}
+
+// Validate checks that the geometry can be stored as a .b file
+func (g Geometry) Validate() error {
+	if g.Width <= 0 || g.Height <= 0 || g.Height%PageRows != 0 {
+		return fmt.Errorf("%w: %dx%d is not a valid display, the height has to be a multiple of %d", ErrSize, g.Width, g.Height, PageRows)
+	}
+	return nil
+}
+
+// String returns the geometry as WIDTHxHEIGHT
+func (g Geometry) String() string {
+	return fmt.Sprintf("%dx%d", g.Width, g.Height)
+}
+
+// GeometryForSize returns the known geometry of a .b file with size bytes
+func GeometryForSize(size int) (Geometry, error) {
+	for _, g := range KnownGeometries {
+		if g.Size() == size {
+			return g, nil
+		}
+	}
+	return Geometry{}, fmt.Errorf("%w: %d bytes does not match any known display", ErrSize, size)
+}
+
+// Palette is the palette of decoded images, index 0 is an unlit pixel
+var Palette = color.Palette{color.Black, color.White}
+
+// ErrSize is returned when a file or image is not the size of the display
+var ErrSize = errors.New("bimage: wrong size")
+
+func init() {
+	image.RegisterFormat("b", "", Decode, DecodeConfig)
+}
+
+// maxKnownSize is the biggest file that can be decoded without being given a geometry
+func maxKnownSize() int {
+	size := 0
+	for _, g := range KnownGeometries {
+		if g.Size() > size {
+			size = g.Size()
+		}
+	}
+	return size
+}
+
+// Validate checks that data is a complete .b file for one of the known geometries
+func Validate(data []byte) error {
+	_, err := GeometryForSize(len(data))
+	return err
+}
+
+// ValidateGeometry checks that data is a complete .b file for the geometry
+func ValidateGeometry(data []byte, g Geometry) error {
+	if err := g.Validate(); err != nil {
+		return err
+	}
+	if len(data) != g.Size() {
+		return fmt.Errorf("%w: got %d bytes, need %d for %s", ErrSize, len(data), g.Size(), g)
+	}
+	return nil
+}
+
+// ValidateFile checks that the file at path is a complete .b file for one of the known geometries
+func ValidateFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if _, err := GeometryForSize(int(info.Size())); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Unmarshal converts the contents of a .b file into an image
+// The geometry is worked out from the size of data
+func Unmarshal(data []byte) (*image.Paletted, error) {
+	g, err := GeometryForSize(len(data))
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalGeometry(data, g)
+}
+
+// UnmarshalGeometry converts the contents of a .b file for a display of geometry g into an image
+func UnmarshalGeometry(data []byte, g Geometry) (*image.Paletted, error) {
+	if err := ValidateGeometry(data, g); err != nil {
+		return nil, err
+	}
+	img := image.NewPaletted(image.Rect(0, 0, g.Width, g.Height), Palette)
+	for page := 0; page < g.Pages(); page++ {
+		for x := 0; x < g.Width; x++ {
+			column := data[page*g.Width+x]
+			for bit := 0; bit < PageRows; bit++ {
+				if column&(1<<uint(bit)) != 0 {
+					img.SetColorIndex(x, page*PageRows+bit, 1)
+				}
+			}
+		}
+	}
+	return img, nil
+}
+
+// Decode reads a .b file from r
+// Anything that is not the size of a known .b file returns image.ErrFormat so image.Decode reports an unknown format
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(maxKnownSize()+1)))
+	if err != nil {
+		return nil, err
+	}
+	img, err := Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", image.ErrFormat, err)
+	}
+	return img, nil
+}
+
+// DecodeConfig returns the size and colour model of a .b file without decoding it
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(maxKnownSize()+1)))
+	if err != nil {
+		return image.Config{}, err
+	}
+	g, err := GeometryForSize(len(data))
+	if err != nil {
+		return image.Config{}, fmt.Errorf("%w: %v", image.ErrFormat, err)
+	}
+	return image.Config{ColorModel: Palette, Width: g.Width, Height: g.Height}, nil
+}
+
+// Lit reports if a pixel should be turned on, anything at or above half brightness is lit
+func Lit(c color.Color) bool {
+	return color.GrayModel.Convert(c).(color.Gray).Y >= 128
+}
+
+// Marshal converts an image into the contents of a .b file
+// The image is stored at its own size, its height has to be a multiple of 8
+func Marshal(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	g := Geometry{Width: bounds.Dx(), Height: bounds.Dy()}
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	data := make([]byte, g.Size())
+	for page := 0; page < g.Pages(); page++ {
+		for x := 0; x < g.Width; x++ {
+			var column byte
+			for bit := 0; bit < PageRows; bit++ {
+				if Lit(img.At(bounds.Min.X+x, bounds.Min.Y+page*PageRows+bit)) {
+					column |= 1 << uint(bit)
+				}
+			}
+			data[page*g.Width+x] = column
+		}
+	}
+	return data, nil
+}
+
+// Encode writes img to w as a .b file
+func Encode(w io.Writer, img image.Image) error {
+	data, err := Marshal(img)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// JoinPages flattens the pages returned by deejdsp.ConvertImage into the contents of a .b file
+// Every page has to be the same width
+func JoinPages(pages [][]byte) ([]byte, error) {
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("%w: got no pages", ErrSize)
+	}
+	width := len(pages[0])
+	data := make([]byte, 0, width*len(pages))
+	for index, page := range pages {
+		if len(page) != width || width == 0 {
+			return nil, fmt.Errorf("%w: page %d is %d bytes, need %d", ErrSize, index, len(page), width)
+		}
+		data = append(data, page...)
+	}
+	return data, nil
+}
+
+// ReadFile decodes the .b file at path
+func ReadFile(path string) (*image.Paletted, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Unmarshal(data)
+}
+
+// WriteFile encodes img as a .b file at path
+func WriteFile(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := Encode(w, img); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,129 @@
+package bimage
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// testImage returns a checkerboard of 4 pixel squares so every page has lit and unlit rows
+func testImage(g Geometry) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, g.Width, g.Height))
+	for y := 0; y < g.Height; y++ {
+		for x := 0; x < g.Width; x++ {
+			if (x/4+y/4)%2 == 0 {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
+
+// checkSame checks that every pixel of got is lit where want is
+func checkSame(t *testing.T, got, want image.Image) {
+	t.Helper()
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("got bounds %v, want %v", got.Bounds(), want.Bounds())
+	}
+	bounds := want.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if Lit(got.At(x, y)) != Lit(want.At(x, y)) {
+				t.Fatalf("pixel %d,%d is different", x, y)
+			}
+		}
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, g := range KnownGeometries {
+		t.Run(g.String(), func(t *testing.T) {
+			img := testImage(g)
+			data, err := Marshal(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) != g.Size() {
+				t.Fatalf("got %d bytes, want %d", len(data), g.Size())
+			}
+			decoded, err := UnmarshalGeometry(data, g)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSame(t, decoded, img)
+		})
+	}
+}
+
+func TestMarshalLayout(t *testing.T) {
+	// the top left pixel is the lowest bit of the first byte, the next page starts after a full row of columns
+	img := image.NewGray(image.Rect(0, 0, Width, Height))
+	img.SetGray(0, 0, color.Gray{Y: 255})
+	img.SetGray(1, 7, color.Gray{Y: 255})
+	img.SetGray(2, 8, color.Gray{Y: 255})
+	data, err := Marshal(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 0x01 || data[1] != 0x80 || data[Width+2] != 0x01 {
+		t.Errorf("got bytes %#x %#x %#x, want 0x1 0x80 0x1", data[0], data[1], data[Width+2])
+	}
+}
+
+func TestImageDecode(t *testing.T) {
+	data, err := Marshal(testImage(Default))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1024 {
+		t.Fatalf("got %d bytes, want 1024", len(data))
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "b" {
+		t.Errorf("decoded as %q, want b", format)
+	}
+	checkSame(t, img, testImage(Default))
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "b" || config.Width != Width || config.Height != Height {
+		t.Errorf("got config %dx%d %q %v, want 128x64 b", config.Width, config.Height, format, err)
+	}
+
+	// files with a magic number are still found before the fallback
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(Default)); err != nil {
+		t.Fatal(err)
+	}
+	if _, format, err := image.Decode(&buf); err != nil || format != "png" {
+		t.Errorf("png decoded as %q: %v", format, err)
+	}
+
+	// anything else is an unknown format
+	if _, _, err := image.Decode(bytes.NewReader(data[:1000])); !errors.Is(err, image.ErrFormat) {
+		t.Errorf("got error %v for 1000 bytes, want image.ErrFormat", err)
+	}
+}
+
+func TestErrSize(t *testing.T) {
+	if _, err := Unmarshal(make([]byte, 1000)); !errors.Is(err, ErrSize) {
+		t.Errorf("Unmarshal: got %v, want ErrSize", err)
+	}
+	if err := ValidateGeometry(make([]byte, 1024), Geometry{Width: 128, Height: 32}); !errors.Is(err, ErrSize) {
+		t.Errorf("ValidateGeometry: got %v, want ErrSize", err)
+	}
+	if _, err := Marshal(image.NewGray(image.Rect(0, 0, 128, 60))); !errors.Is(err, ErrSize) {
+		t.Errorf("Marshal: got %v, want ErrSize for a height that is not a multiple of 8", err)
+	}
+	if _, err := GeometryForSize(1000); !errors.Is(err, ErrSize) {
+		t.Errorf("GeometryForSize: got %v, want ErrSize", err)
+	}
+	if err := Validate(make([]byte, 512)); err != nil {
+		t.Errorf("Validate: a 128x32 file should be valid, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package bimage
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// DefaultPreviewScale is how many screen pixels are used for each display pixel in a preview
+const DefaultPreviewScale = 4
+
+// Preview colours, lit pixels are drawn like a white oled
+var (
+	PreviewOn  = color.RGBA{0xf0, 0xf4, 0xff, 0xff}
+	PreviewOff = color.RGBA{0x10, 0x10, 0x14, 0xff}
+)
+
+// Preview draws a display image scaled up by scale so it can be viewed on a computer
+func Preview(img image.Image, scale int) *image.RGBA {
+	if scale < 1 {
+		scale = 1
+	}
+	bounds := img.Bounds()
+	preview := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*scale, bounds.Dy()*scale))
+	draw.Draw(preview, preview.Bounds(), image.NewUniform(PreviewOff), image.ZP, draw.Src)
+	on := image.NewUniform(PreviewOn)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			if Lit(img.At(bounds.Min.X+x, bounds.Min.Y+y)) {
+				pixel := image.Rect(x*scale, y*scale, (x+1)*scale, (y+1)*scale)
+				draw.Draw(preview, pixel, on, image.ZP, draw.Src)
+			}
+		}
+	}
+	return preview
+}
+
+// WritePreview decodes the contents of a .b file and writes it to w as a scaled up png
+func WritePreview(w io.Writer, data []byte, scale int) error {
+	img, err := Unmarshal(data)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, Preview(img, scale))
+}
+
+// SheetEntry is a single image on a contact sheet
+// A nil Image is drawn as an empty display
+type SheetEntry struct {
+	Label string
+	Image image.Image
+}
+
+// contact sheet layout in pixels
+const (
+	sheetPadding     = 8
+	sheetLabelHeight = 16
+)
+
+// ContactSheet lays out the entries in a grid with their labels underneath
+// Each entry is drawn at the given preview scale and columns sets how many entries are on a row
+func ContactSheet(entries []SheetEntry, scale int, columns int) *image.RGBA {
+	if scale < 1 {
+		scale = 1
+	}
+	if columns < 1 {
+		columns = 1
+	}
+	if columns > len(entries) && len(entries) > 0 {
+		columns = len(entries)
+	}
+	rows := (len(entries) + columns - 1) / columns
+
+	// every cell is the size of the biggest display
+	largest := image.Rect(0, 0, Width, Height)
+	for _, entry := range entries {
+		if entry.Image != nil {
+			largest = largest.Union(image.Rect(0, 0, entry.Image.Bounds().Dx(), entry.Image.Bounds().Dy()))
+		}
+	}
+	cellX := largest.Dx()*scale + sheetPadding
+	cellY := largest.Dy()*scale + sheetLabelHeight + sheetPadding
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*cellX+sheetPadding, rows*cellY+sheetPadding))
+	draw.Draw(sheet, sheet.Bounds(), image.White, image.ZP, draw.Src)
+
+	drawer := &font.Drawer{
+		Dst:  sheet,
+		Src:  image.Black,
+		Face: basicfont.Face7x13,
+	}
+	for index, entry := range entries {
+		origin := image.Pt(sheetPadding+(index%columns)*cellX, sheetPadding+(index/columns)*cellY)
+		frame := image.Rect(0, 0, Width*scale, Height*scale).Add(origin)
+		if entry.Image != nil {
+			frame = image.Rect(0, 0, entry.Image.Bounds().Dx()*scale, entry.Image.Bounds().Dy()*scale).Add(origin)
+		}
+
+		if entry.Image != nil {
+			draw.Draw(sheet, frame, Preview(entry.Image, scale), image.ZP, draw.Src)
+		} else {
+			draw.Draw(sheet, frame, image.NewUniform(PreviewOff), image.ZP, draw.Src)
+		}
+
+		drawer.Dot = fixed.P(origin.X, origin.Y+largest.Dy()*scale+sheetLabelHeight-3)
+		drawer.DrawString(entry.Label)
+	}
+	return sheet
+}
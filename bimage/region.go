@@ -0,0 +1,94 @@
+package bimage
+
+import (
+	"fmt"
+)
+
+// Region is a window of the display ram measured in columns and 8 row pages
+// This is the window set by the ssd1306 column and page address commands
+type Region struct {
+	Column int
+	Page   int
+	Width  int
+	Pages  int
+}
+
+// Empty reports if the region covers nothing
+func (r Region) Empty() bool {
+	return r.Width <= 0 || r.Pages <= 0
+}
+
+// Size returns the number of bytes needed to fill the region
+func (r Region) Size() int {
+	if r.Empty() {
+		return 0
+	}
+	return r.Width * r.Pages
+}
+
+// Validate checks that the region fits on a display of geometry g
+func (r Region) Validate(g Geometry) error {
+	if r.Empty() || r.Column < 0 || r.Page < 0 || r.Column+r.Width > g.Width || r.Page+r.Pages > g.Pages() {
+		return fmt.Errorf("%w: region %+v does not fit on a %s display", ErrSize, r, g)
+	}
+	return nil
+}
+
+// String returns the region as columns and pages
+func (r Region) String() string {
+	return fmt.Sprintf("columns %d-%d pages %d-%d", r.Column, r.Column+r.Width-1, r.Page, r.Page+r.Pages-1)
+}
+
+// DirtyRegion returns the smallest region that covers every byte that is different between two .b files
+// ok is false if the frames are the same
+func DirtyRegion(previous, next []byte, g Geometry) (region Region, ok bool, err error) {
+	if err := ValidateGeometry(previous, g); err != nil {
+		return Region{}, false, err
+	}
+	if err := ValidateGeometry(next, g); err != nil {
+		return Region{}, false, err
+	}
+
+	minColumn, minPage := g.Width, g.Pages()
+	maxColumn, maxPage := -1, -1
+	for page := 0; page < g.Pages(); page++ {
+		for column := 0; column < g.Width; column++ {
+			index := page*g.Width + column
+			if previous[index] == next[index] {
+				continue
+			}
+			if column < minColumn {
+				minColumn = column
+			}
+			if column > maxColumn {
+				maxColumn = column
+			}
+			if page < minPage {
+				minPage = page
+			}
+			if page > maxPage {
+				maxPage = page
+			}
+		}
+	}
+	if maxColumn < 0 {
+		return Region{}, false, nil
+	}
+	return Region{Column: minColumn, Page: minPage, Width: maxColumn - minColumn + 1, Pages: maxPage - minPage + 1}, true, nil
+}
+
+// ExtractRegion copies the bytes inside a region out of a .b file, page by page
+func ExtractRegion(data []byte, g Geometry, r Region) ([]byte, error) {
+	if err := ValidateGeometry(data, g); err != nil {
+		return nil, err
+	}
+	if err := r.Validate(g); err != nil {
+		return nil, err
+	}
+	region := make([]byte, 0, r.Size())
+	for page := r.Page; page < r.Page+r.Pages; page++ {
+		start := page*g.Width + r.Column
+		region = append(region, data[start:start+r.Width]...)
+	}
+	return region, nil
+}
@@ -0,0 +1,125 @@
+package bimage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Compressed files are a .b file run length encoded with PackBits
+//
+// The file starts with a header of the letters "BR" and the width and height as single bytes.
+// The rest of the file is made of runs, each starting with a control byte:
+// 0-127 copies the next control+1 bytes as they are, 129-255 repeats the next byte 257-control times
+// and 128 is skipped. Mostly black images shrink to a few bytes.
+const (
+	CompressedMagic      = "BR"
+	compressedHeaderSize = len(CompressedMagic) + 2
+	maxLiteralRun        = 128
+	maxRepeatRun         = 128
+)
+
+// ErrCompressed is returned when compressed data can not be expanded
+var ErrCompressed = errors.New("bimage: bad compressed data")
+
+// IsCompressed reports if data starts like a compressed file
+func IsCompressed(data []byte) bool {
+	return len(data) >= compressedHeaderSize && string(data[:len(CompressedMagic)]) == CompressedMagic
+}
+
+// Compress run length encodes the contents of a .b file for a display of geometry g
+func Compress(data []byte, g Geometry) ([]byte, error) {
+	if err := ValidateGeometry(data, g); err != nil {
+		return nil, err
+	}
+	if g.Width > 0xff || g.Height > 0xff {
+		return nil, fmt.Errorf("%w: %s is too big to compress", ErrSize, g)
+	}
+	compressed := make([]byte, 0, compressedHeaderSize+len(data)/4)
+	compressed = append(compressed, CompressedMagic...)
+	compressed = append(compressed, byte(g.Width), byte(g.Height))
+	return append(compressed, packBits(data)...), nil
+}
+
+// Decompress expands a compressed file back into the contents of a .b file
+func Decompress(compressed []byte) ([]byte, Geometry, error) {
+	if !IsCompressed(compressed) {
+		return nil, Geometry{}, fmt.Errorf("%w: missing %q header", ErrCompressed, CompressedMagic)
+	}
+	g := Geometry{Width: int(compressed[2]), Height: int(compressed[3])}
+	if err := g.Validate(); err != nil {
+		return nil, g, err
+	}
+	data, err := unpackBits(compressed[compressedHeaderSize:], g.Size())
+	if err != nil {
+		return nil, g, err
+	}
+	return data, g, nil
+}
+
+// packBits encodes data using the PackBits run length encoding
+func packBits(data []byte) []byte {
+	var packed []byte
+	literalStart := 0
+	flushLiterals := func(end int) {
+		for literalStart < end {
+			count := end - literalStart
+			if count > maxLiteralRun {
+				count = maxLiteralRun
+			}
+			packed = append(packed, byte(count-1))
+			packed = append(packed, data[literalStart:literalStart+count]...)
+			literalStart += count
+		}
+	}
+
+	for index := 0; index < len(data); {
+		run := 1
+		for index+run < len(data) && run < maxRepeatRun && data[index+run] == data[index] {
+			run++
+		}
+		// a run of 2 only saves space when it is not breaking up literals
+		if run >= 3 || (run == 2 && literalStart == index) {
+			flushLiterals(index)
+			packed = append(packed, byte(257-run), data[index])
+			index += run
+			literalStart = index
+			continue
+		}
+		index += run
+	}
+	flushLiterals(len(data))
+	return packed
+}
+
+// unpackBits decodes PackBits data that expands to exactly size bytes
+func unpackBits(packed []byte, size int) ([]byte, error) {
+	data := make([]byte, 0, size)
+	for index := 0; index < len(packed); {
+		control := int(packed[index])
+		index++
+		switch {
+		case control < 128:
+			count := control + 1
+			if index+count > len(packed) {
+				return nil, fmt.Errorf("%w: literal run of %d bytes is cut off", ErrCompressed, count)
+			}
+			data = append(data, packed[index:index+count]...)
+			index += count
+		case control > 128:
+			if index >= len(packed) {
+				return nil, fmt.Errorf("%w: repeat run is cut off", ErrCompressed)
+			}
+			for count := 257 - control; count > 0; count-- {
+				data = append(data, packed[index])
+			}
+			index++
+		}
+		if len(data) > size {
+			break
+		}
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("%w: expanded to %d bytes, need %d", ErrCompressed, len(data), size)
+	}
+	return data, nil
+}
@@ -0,0 +1,120 @@
+package bimage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Animation files hold a sequence of .b frames that the arduino plays from the sd card
+//
+// The file starts with a header of the letters "BA", the width and height as single bytes
+// and the number of frames as 2 bytes with the high byte first.
+// Every frame is the delay before the next frame in milliseconds as 2 bytes with the high byte first
+// followed by the frame itself in the same layout as a .b file.
+const (
+	SequenceMagic      = "BA"
+	sequenceHeaderSize = len(SequenceMagic) + 4
+	frameHeaderSize    = 2
+)
+
+// Limits of the animation file format
+const (
+	MaxFrames     = 0xffff
+	MaxFrameDelay = 0xffff * time.Millisecond
+)
+
+// Frame is a single image of an animation and how long it is shown for
+// Data is the contents of a .b file for the geometry of the sequence
+type Frame struct {
+	Data  []byte
+	Delay time.Duration
+}
+
+// Sequence is an animation for a single display
+type Sequence struct {
+	Geometry Geometry
+	Frames   []Frame
+}
+
+// Validate checks that the sequence can be stored as an animation file
+func (seq *Sequence) Validate() error {
+	if err := seq.Geometry.Validate(); err != nil {
+		return err
+	}
+	if seq.Geometry.Width > 0xff || seq.Geometry.Height > 0xff {
+		return fmt.Errorf("%w: %s is too big for an animation", ErrSize, seq.Geometry)
+	}
+	if len(seq.Frames) == 0 || len(seq.Frames) > MaxFrames {
+		return fmt.Errorf("%w: animations need 1-%d frames, got %d", ErrSize, MaxFrames, len(seq.Frames))
+	}
+	for index, frame := range seq.Frames {
+		if err := ValidateGeometry(frame.Data, seq.Geometry); err != nil {
+			return fmt.Errorf("frame %d: %w", index, err)
+		}
+		if frame.Delay < 0 || frame.Delay > MaxFrameDelay {
+			return fmt.Errorf("frame %d: delay has to be 0-%s, got %s", index, MaxFrameDelay, frame.Delay)
+		}
+	}
+	return nil
+}
+
+// Duration returns how long one loop of the animation takes
+func (seq *Sequence) Duration() time.Duration {
+	var total time.Duration
+	for _, frame := range seq.Frames {
+		total += frame.Delay
+	}
+	return total
+}
+
+// IsSequence reports if data starts like an animation file
+func IsSequence(data []byte) bool {
+	return len(data) >= sequenceHeaderSize && string(data[:len(SequenceMagic)]) == SequenceMagic
+}
+
+// MarshalSequence converts a sequence into the contents of an animation file
+func MarshalSequence(seq *Sequence) ([]byte, error) {
+	if err := seq.Validate(); err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, sequenceHeaderSize+len(seq.Frames)*(frameHeaderSize+seq.Geometry.Size()))
+	data = append(data, SequenceMagic...)
+	data = append(data, byte(seq.Geometry.Width), byte(seq.Geometry.Height))
+	data = append(data, byte(len(seq.Frames)>>8), byte(len(seq.Frames)))
+	for _, frame := range seq.Frames {
+		delay := uint16(frame.Delay / time.Millisecond)
+		data = append(data, byte(delay>>8), byte(delay))
+		data = append(data, frame.Data...)
+	}
+	return data, nil
+}
+
+// UnmarshalSequence reads the contents of an animation file
+func UnmarshalSequence(data []byte) (*Sequence, error) {
+	if !IsSequence(data) {
+		return nil, fmt.Errorf("%w: not an animation file", ErrSize)
+	}
+	g := Geometry{Width: int(data[2]), Height: int(data[3])}
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	count := int(binary.BigEndian.Uint16(data[4:6]))
+	need := sequenceHeaderSize + count*(frameHeaderSize+g.Size())
+	if count == 0 || len(data) != need {
+		return nil, fmt.Errorf("%w: got %d bytes, need %d for %d frames of %s", ErrSize, len(data), need, count, g)
+	}
+
+	seq := &Sequence{Geometry: g, Frames: make([]Frame, count)}
+	offset := sequenceHeaderSize
+	for index := range seq.Frames {
+		delay := binary.BigEndian.Uint16(data[offset : offset+frameHeaderSize])
+		offset += frameHeaderSize
+		seq.Frames[index] = Frame{
+			Data:  data[offset : offset+g.Size()],
+			Delay: time.Duration(delay) * time.Millisecond,
+		}
+		offset += g.Size()
+	}
+	return seq, nil
+}
@@ -0,0 +1,301 @@
+// Package cimage reads and writes the .c colour image files shown on the ssd1331 and ssd1351 colour oleds
+//
+// A raw .c file is the RGB565 graphics ram of the display, two bytes per pixel with the high byte first,
+// stored row by row starting at the top left. This is the order the colour controllers expect the data in.
+//
+// A palette compressed .c file starts with a header of the letters "P8", the width, the height and the
+// number of colours minus one, each stored as a single byte. The header is followed by the palette as
+// RGB565 colours and then one palette index per pixel in the same order as a raw file.
+//
+// Palette files are told apart from raw files by the magic and by their length matching the size in the header.
+package cimage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Geometry is the size in pixels of a colour display
+type Geometry struct {
+	Width  int
+	Height int
+}
+
+// KnownGeometries are the panel sizes that raw files can be detected from
+var KnownGeometries = []Geometry{
+	{Width: 96, Height: 64},   // ssd1331
+	{Width: 128, Height: 128}, // ssd1351
+	{Width: 128, Height: 96},  // ssd1351
+}
+
+// Pixels returns the number of pixels on the display
+func (g Geometry) Pixels() int {
+	return g.Width * g.Height
+}
+
+// Size returns the number of bytes in a raw .c file for the display
+func (g Geometry) Size() int {
+	return g.Pixels() * 2
+}
+
+// Validate checks that the geometry can be stored as a .c file
+func (g Geometry) Validate() error {
+	if g.Width <= 0 || g.Height <= 0 || g.Width > 255 || g.Height > 255 {
+		return fmt.Errorf("%w: %dx%d is not a valid display, each side has to be 1-255 pixels", ErrSize, g.Width, g.Height)
+	}
+	return nil
+}
+
+// String returns the geometry as WIDTHxHEIGHT
+func (g Geometry) String() string {
+	return fmt.Sprintf("%dx%d", g.Width, g.Height)
+}
+
+// GeometryForSize returns the known geometry of a raw .c file with size bytes
+func GeometryForSize(size int) (Geometry, error) {
+	for _, g := range KnownGeometries {
+		if g.Size() == size {
+			return g, nil
+		}
+	}
+	return Geometry{}, fmt.Errorf("%w: %d bytes does not match any known display", ErrSize, size)
+}
+
+// MaxPaletteColors is the most colours a palette compressed file can hold
+const MaxPaletteColors = 256
+
+// paletteMagic starts every palette compressed file
+const paletteMagic = "P8"
+
+// paletteHeaderSize is the magic followed by the width, height and number of colours
+const paletteHeaderSize = len(paletteMagic) + 3
+
+// Errors returned when a file is not a valid .c file
+var (
+	ErrSize   = errors.New("cimage: wrong size")
+	ErrFormat = errors.New("cimage: not a .c file")
+)
+
+// RGB565 packs a colour into the 16 bit format used by the colour displays
+func RGB565(c color.Color) uint16 {
+	rgba := color.RGBAModel.Convert(c).(color.RGBA)
+	return uint16(rgba.R>>3)<<11 | uint16(rgba.G>>2)<<5 | uint16(rgba.B>>3)
+}
+
+// Color565 unpacks a 16 bit colour, the low bits are filled from the high bits so white stays white
+func Color565(value uint16) color.RGBA {
+	r := uint8(value>>11) & 0x1f
+	g := uint8(value>>5) & 0x3f
+	b := uint8(value) & 0x1f
+	return color.RGBA{R: r<<3 | r>>2, G: g<<2 | g>>4, B: b<<3 | b>>2, A: 0xff}
+}
+
+// IsPalette reports if data is a palette compressed file
+// The file has to start with the magic and be exactly as long as its header says
+func IsPalette(data []byte) bool {
+	if len(data) < paletteHeaderSize || string(data[:len(paletteMagic)]) != paletteMagic {
+		return false
+	}
+	g := Geometry{Width: int(data[2]), Height: int(data[3])}
+	colors := int(data[4]) + 1
+	return g.Validate() == nil && len(data) == paletteHeaderSize+colors*2+g.Pixels()
+}
+
+// Validate checks that data is a complete raw file for one of the known geometries or a complete palette file
+func Validate(data []byte) error {
+	_, err := DecodeConfig(data)
+	return err
+}
+
+// ValidateFile checks that the file at path is a complete .c file
+func ValidateFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := Validate(data); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// DecodeConfig returns the size of the image stored in data without decoding it
+func DecodeConfig(data []byte) (image.Config, error) {
+	if !IsPalette(data) {
+		g, err := GeometryForSize(len(data))
+		if err != nil {
+			// a broken palette file explains more than a raw file of the wrong size
+			if len(data) >= paletteHeaderSize && string(data[:len(paletteMagic)]) == paletteMagic {
+				return decodePaletteConfig(data)
+			}
+			return image.Config{}, err
+		}
+		return image.Config{ColorModel: color.RGBAModel, Width: g.Width, Height: g.Height}, nil
+	}
+	return decodePaletteConfig(data)
+}
+
+// decodePaletteConfig reads the header and palette of a palette compressed file
+func decodePaletteConfig(data []byte) (image.Config, error) {
+	g := Geometry{Width: int(data[2]), Height: int(data[3])}
+	colors := int(data[4]) + 1
+	if err := g.Validate(); err != nil {
+		return image.Config{}, err
+	}
+	need := paletteHeaderSize + colors*2 + g.Pixels()
+	if len(data) != need {
+		return image.Config{}, fmt.Errorf("%w: got %d bytes, need %d for a %s image with %d colours", ErrSize, len(data), need, g, colors)
+	}
+	palette := make(color.Palette, colors)
+	for index := range palette {
+		offset := paletteHeaderSize + index*2
+		palette[index] = Color565(uint16(data[offset])<<8 | uint16(data[offset+1]))
+	}
+	return image.Config{ColorModel: palette, Width: g.Width, Height: g.Height}, nil
+}
+
+// Unmarshal converts the contents of a .c file into an image
+// Raw files return a *image.RGBA and palette files return a *image.Paletted
+func Unmarshal(data []byte) (image.Image, error) {
+	config, err := DecodeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	bounds := image.Rect(0, 0, config.Width, config.Height)
+
+	if palette, ok := config.ColorModel.(color.Palette); ok {
+		img := image.NewPaletted(bounds, palette)
+		pixels := data[paletteHeaderSize+len(palette)*2:]
+		for index, value := range pixels {
+			if int(value) >= len(palette) {
+				return nil, fmt.Errorf("%w: pixel %d uses colour %d of a %d colour palette", ErrFormat, index, value, len(palette))
+			}
+		}
+		copy(img.Pix, pixels)
+		return img, nil
+	}
+
+	img := image.NewRGBA(bounds)
+	for index := 0; index < config.Width*config.Height; index++ {
+		c := Color565(uint16(data[index*2])<<8 | uint16(data[index*2+1]))
+		img.SetRGBA(index%config.Width, index/config.Width, c)
+	}
+	return img, nil
+}
+
+// Marshal converts an image into the contents of a raw .c file at the size of the image
+func Marshal(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	g := Geometry{Width: bounds.Dx(), Height: bounds.Dy()}
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, g.Size())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			value := RGB565(img.At(x, y))
+			data = append(data, byte(value>>8), byte(value))
+		}
+	}
+	return data, nil
+}
+
+// MarshalPalette converts an image into the contents of a palette compressed .c file
+// Images with more than maxColors colours are reduced using median cut
+func MarshalPalette(img image.Image, maxColors int) ([]byte, error) {
+	if maxColors < 1 || maxColors > MaxPaletteColors {
+		return nil, fmt.Errorf("cimage: palette needs 1-%d colours, got %d", MaxPaletteColors, maxColors)
+	}
+	bounds := img.Bounds()
+	g := Geometry{Width: bounds.Dx(), Height: bounds.Dy()}
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+
+	// count the colours after they have been reduced to what the display can show
+	pixels := make([]uint16, 0, g.Pixels())
+	histogram := make(map[uint16]int)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			value := RGB565(img.At(x, y))
+			pixels = append(pixels, value)
+			histogram[value]++
+		}
+	}
+	palette := medianCut(histogram, maxColors)
+
+	data := make([]byte, 0, paletteHeaderSize+len(palette)*2+len(pixels))
+	data = append(data, paletteMagic...)
+	data = append(data, byte(g.Width), byte(g.Height), byte(len(palette)-1))
+	for _, value := range palette {
+		data = append(data, byte(value>>8), byte(value))
+	}
+	indexes := make(map[uint16]byte, len(histogram))
+	for _, value := range pixels {
+		index, ok := indexes[value]
+		if !ok {
+			index = nearest(palette, value)
+			indexes[value] = index
+		}
+		data = append(data, index)
+	}
+	return data, nil
+}
+
+// Decode reads a .c file from r
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Unmarshal(data)
+}
+
+// Encode writes img to w as a raw .c file, maxColors above 0 writes a palette compressed file instead
+func Encode(w io.Writer, img image.Image, maxColors int) error {
+	var data []byte
+	var err error
+	if maxColors > 0 {
+		data, err = MarshalPalette(img, maxColors)
+	} else {
+		data, err = Marshal(img)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadFile decodes the .c file at path
+func ReadFile(path string) (image.Image, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Unmarshal(data)
+}
+
+// WriteFile encodes img as a .c file at path, see Encode for maxColors
+func WriteFile(path string, img image.Image, maxColors int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := Encode(w, img, maxColors); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,123 @@
+package cimage
+
+import (
+	"sort"
+)
+
+// colorCount is a colour found in an image and how many pixels use it
+type colorCount struct {
+	rgb   [3]int
+	value uint16
+	count int
+}
+
+// colorBox is a group of colours that will become one palette entry
+type colorBox []colorCount
+
+// widestChannel returns the channel with the biggest spread of values in the box and the size of the spread
+func (box colorBox) widestChannel() (int, int) {
+	channel, spread := 0, -1
+	for c := 0; c < 3; c++ {
+		low, high := box[0].rgb[c], box[0].rgb[c]
+		for _, entry := range box {
+			if entry.rgb[c] < low {
+				low = entry.rgb[c]
+			}
+			if entry.rgb[c] > high {
+				high = entry.rgb[c]
+			}
+		}
+		if high-low > spread {
+			channel, spread = c, high-low
+		}
+	}
+	return channel, spread
+}
+
+// average returns the colour of the box weighted by how many pixels use each colour
+func (box colorBox) average() uint16 {
+	var sum [3]int
+	total := 0
+	for _, entry := range box {
+		for c := range sum {
+			sum[c] += entry.rgb[c] * entry.count
+		}
+		total += entry.count
+	}
+	r, g, b := (sum[0]+total/2)/total, (sum[1]+total/2)/total, (sum[2]+total/2)/total
+	return uint16(r>>3)<<11 | uint16(g>>2)<<5 | uint16(b>>3)
+}
+
+// medianCut picks at most maxColors colours that best cover the histogram
+// Images that already have few enough colours keep them exactly
+func medianCut(histogram map[uint16]int, maxColors int) []uint16 {
+	entries := make(colorBox, 0, len(histogram))
+	for value, count := range histogram {
+		c := Color565(value)
+		entries = append(entries, colorCount{rgb: [3]int{int(c.R), int(c.G), int(c.B)}, value: value, count: count})
+	}
+	// map order is random, sort so the same image always gives the same palette
+	sort.Slice(entries, func(i, j int) bool { return entries[i].value < entries[j].value })
+
+	if len(entries) <= maxColors {
+		palette := make([]uint16, len(entries))
+		for index, entry := range entries {
+			palette[index] = entry.value
+		}
+		return palette
+	}
+
+	boxes := []colorBox{entries}
+	for len(boxes) < maxColors {
+		// split the box with the widest spread of colours
+		split, splitChannel, widest := -1, 0, 0
+		for index, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			channel, spread := box.widestChannel()
+			if spread > widest {
+				split, splitChannel, widest = index, channel, spread
+			}
+		}
+		if split < 0 {
+			break
+		}
+
+		box := boxes[split]
+		sort.SliceStable(box, func(i, j int) bool { return box[i].rgb[splitChannel] < box[j].rgb[splitChannel] })
+		total := 0
+		for _, entry := range box {
+			total += entry.count
+		}
+		// cut at the pixel median, leaving at least one colour on each side
+		median, seen := 1, box[0].count
+		for median < len(box)-1 && seen < total/2 {
+			seen += box[median].count
+			median++
+		}
+		boxes[split] = box[:median]
+		boxes = append(boxes, box[median:])
+	}
+
+	palette := make([]uint16, len(boxes))
+	for index, box := range boxes {
+		palette[index] = box.average()
+	}
+	return palette
+}
+
+// nearest returns the index of the palette colour closest to value
+func nearest(palette []uint16, value uint16) byte {
+	c := Color565(value)
+	best, bestDistance := 0, -1
+	for index, entry := range palette {
+		p := Color565(entry)
+		dr, dg, db := int(c.R)-int(p.R), int(c.G)-int(p.G), int(c.B)-int(p.B)
+		distance := dr*dr + dg*dg + db*db
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = index, distance
+		}
+	}
+	return byte(best)
+}
@@ -3,23 +3,35 @@ package main
 import (
 	"flag"
 	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/jax-b/deej/pkg/deej"
 	"github.com/jax-b/deejdsp"
-	"github.com/jax-b/iconfinderapi"
+	"github.com/jax-b/deejdsp/bimage"
+	"github.com/jax-b/deejdsp/cimage"
 	"github.com/sqweek/dialog"
 	"go.uber.org/zap"
 )
 
+// file extensions of the images on the sd card that need their own commands
+const (
+	animationExtension  = ".ba"
+	compressedExtension = ".br"
+)
+
 var (
 	gitCommit  string
 	versionTag string
 	buildType  string
 
-	verbose       bool
-	useIconFinder bool
+	verbose bool
 
 	d          *deej.Deej
 	cfgDSP     *deejdsp.DSPCanonicalConfig
@@ -29,9 +41,12 @@ var (
 	siumonitor *deejdsp.SerialInUse
 	sessionMap *deej.SessionMap
 	sliderMap  *deej.SliderMap
-	icofdrapi  *iconfinderapi.Iconfinder
 
 	crntDSPimg map[int]string
+	// geometry last sent to each display
+	crntDSPgeometry map[int]deejdsp.DisplayProfile
+	// optional commands the firmware supports
+	dspFeatures deejdsp.Features
 )
 
 const stopDelay = 50 * time.Millisecond
@@ -88,17 +103,9 @@ func main() {
 	cfgDSP, err = deejdsp.NewDSPConfig(modlogger)
 	cfgDSP.Load()
 
-	// Create IconFinderAPI
-	if strings.EqualFold(cfgDSP.IconFinderDotComAPIKey, "silent") {
-		modlogger.Info("iconfinder.com apikey not set: in order to use online icons please enter a icon finder api key")
-		useIconFinder = false
-	} else if len(cfgDSP.IconFinderDotComAPIKey) > 0 && !strings.EqualFold(cfgDSP.IconFinderDotComAPIKey, "example") {
-		icofdrapi = iconfinderapi.NewIconFinder(cfgDSP.IconFinderDotComAPIKey)
-		useIconFinder = true
-	} else {
-		useIconFinder = false
-		modlogger.Info("iconfinder.com apikey not set: in order to use online icons please enter a icon finder api key")
-		d.Notifier.Notify("iconfinder.com apikey not set", "in order to use online icons please enter a icon finder api key")
+	// Let the user know about icon providers that could not be used
+	for _, notice := range cfgDSP.IconProviderNotices {
+		d.Notifier.Notify("Icon provider disabled", notice)
 	}
 
 	if cfgDSP.StartupDelay > 0 {
@@ -115,6 +122,7 @@ func main() {
 	serDSP, err = deejdsp.NewSerialDSP(serial, siumonitor, modlogger)
 
 	crntDSPimg = make(map[int]string)
+	crntDSPgeometry = make(map[int]deejdsp.DisplayProfile)
 
 	// Tray Menu item: Send Image
 	go func() {
@@ -122,10 +130,18 @@ func main() {
 		menuItem := <-menuItemChan
 		for {
 			<-menuItem.ClickedCh
-			filename, err := dialog.File().Filter("ByteImage", "b").Title("Send Image").Load()
+			filename, err := dialog.File().Filter("ByteImage", "b").Filter("ColorImage", "c").Title("Send Image").Load()
 			if err != nil {
 				break
 			}
+			validate := validateByteImageFile
+			if strings.EqualFold(filepath.Ext(filename), ".c") {
+				validate = cimage.ValidateFile
+			}
+			if err := validate(filename); err != nil {
+				dialog.Message("%s", err.Error()).Title("Send Image").Error()
+				continue
+			}
 			PathElements := strings.Split(filename, "\\")
 			sdFilename := PathElements[len(PathElements)-1]
 			serSD.SendFile(filename, sdFilename)
@@ -133,6 +149,29 @@ func main() {
 		}
 	}()
 	time.Sleep(2 * time.Millisecond)
+	// Tray Menu item: Send Animation
+	go func() {
+		menuItemChan := d.AddMenuItem("Send Animation", "Convert a gif or animated png and send it to the internal SD card")
+		menuItem := <-menuItemChan
+		for {
+			<-menuItem.ClickedCh
+			filename, err := dialog.File().Filter("Animation", "gif", "png").Title("Send Animation").Load()
+			if err != nil {
+				continue
+			}
+			if !dspFeatures.Has(deejdsp.FeatureAnimation) {
+				dialog.Message("%s", "The firmware does not support animations").Title("Send Animation").Error()
+				continue
+			}
+			sdFilenames, err := sendAnimation(filename)
+			if err != nil {
+				dialog.Message("%s", err.Error()).Title("Send Animation").Error()
+				continue
+			}
+			dialog.Message("Sent as %s", strings.Join(sdFilenames, ", ")).Title("Send Animation").Info()
+		}
+	}()
+	time.Sleep(2 * time.Millisecond)
 	// Tray Menu item: List Files
 	go func() {
 		menuItemChan := d.AddMenuItem("List Files", "List the files on the sd card")
@@ -162,7 +201,7 @@ func main() {
 
 			for i := range cfgDSP.DisplayMapping {
 				serTCA.SelectPort(uint8(i))
-				serDSP.DisplayOff()
+				displayOff(cfgDSP.DisplayProfile(i))
 			}
 
 			if resumeAfter {
@@ -190,6 +229,112 @@ func main() {
 			}
 		}
 	}()
+	time.Sleep(2 * time.Millisecond)
+	// Tray Menu Item : Preview a local image file
+	go func() {
+		menuItemChan := d.AddMenuItem("Preview Image", "Save a png preview of a image file")
+		menuItem := <-menuItemChan
+		for {
+			<-menuItem.ClickedCh
+			filename, err := dialog.File().Filter("ByteImage", "b").Title("Preview Image").Load()
+			if err != nil {
+				continue
+			}
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				dialog.Message("%s", err.Error()).Title("Preview Image").Error()
+				continue
+			}
+			savePreview(filename, data)
+		}
+	}()
+	time.Sleep(2 * time.Millisecond)
+	// Tray Menu Item : Preview a file on the sd card
+	go func() {
+		menuItemChan := d.AddMenuItem("Preview Card Image", "Save a png preview of a image file on the sd card")
+		menuItem := <-menuItemChan
+		refresh := menuItem.AddSubMenuItem("Refresh File List", "Read the list of files from the sd card")
+		// the tray can not remove items so files that are gone are hidden
+		cardFiles := make(map[string]trayItem)
+		for {
+			<-refresh.ClickedCh
+			resumeAfter := serial.IsRunning()
+			if serial.IsRunning() {
+				serial.Pause()
+			}
+			files, err := serSD.ListDir()
+			if resumeAfter {
+				serial.Start()
+			}
+			if err != nil {
+				dialog.Message("%s", err.Error()).Title("Preview Card Image").Error()
+				continue
+			}
+
+			present := make(map[string]bool)
+			for _, filename := range files {
+				if !isDisplayFile(filename) {
+					continue
+				}
+				present[filename] = true
+				if item, ok := cardFiles[filename]; ok {
+					item.Show()
+					continue
+				}
+				item := menuItem.AddSubMenuItem(filename, "Save a png preview of "+filename)
+				cardFiles[filename] = item
+				go func(filename string) {
+					for {
+						<-item.ClickedCh
+						resumeAfter := serial.IsRunning()
+						if serial.IsRunning() {
+							serial.Pause()
+						}
+						data, err := serSD.ReadFile(filename)
+						if resumeAfter {
+							serial.Start()
+						}
+						if err != nil {
+							dialog.Message("Could not read %s back from the card: %s", filename, err.Error()).Title("Preview Card Image").Error()
+							continue
+						}
+						savePreview(filename, data)
+					}
+				}(filename)
+			}
+			for filename, item := range cardFiles {
+				if !present[filename] {
+					item.Hide()
+				}
+			}
+		}
+	}()
+	time.Sleep(2 * time.Millisecond)
+	// Tray Menu Item : Contact sheet of all the displays
+	go func() {
+		menuItemChan := d.AddMenuItem("Export Displays", "Save a png with the images currently on all of the displays")
+		menuItem := <-menuItemChan
+		for {
+			<-menuItem.ClickedCh
+			resumeAfter := serial.IsRunning()
+			if serial.IsRunning() {
+				serial.Pause()
+			}
+
+			sheet := createContactSheet(modlogger)
+
+			if resumeAfter {
+				serial.Start()
+			}
+			pngFilename, err := dialog.File().Filter("PNG Image", "png").Title("Export Displays").Save()
+			if err != nil {
+				continue
+			}
+			if err = writePNG(pngFilename, sheet); err != nil {
+				dialog.Message("%s", err.Error()).Title("Export Displays").Error()
+			}
+		}
+	}()
 	_ = serSD
 
 	sessionMap = d.GetSessionMap()
@@ -202,6 +347,13 @@ func main() {
 		serDSP.SetTimeDelay(time)
 	}
 
+	// Older firmware does not support compressed images or animations
+	dspFeatures, err = serDSP.QueryFeatures()
+	if err != nil {
+		modlogger.Named("Display").Debugf("Could not get firmware features: %s", err.Error())
+	}
+	modlogger.Named("Display").Debugf("Firmware features: %v", dspFeatures)
+
 	//Initalise the Displays
 	loadDSPMapings(modlogger)
 
@@ -217,18 +369,6 @@ func main() {
 
 				cfgDSP.Load()
 
-				// Update IconFinderAPIkey
-				if strings.EqualFold(cfgDSP.IconFinderDotComAPIKey, "silent") {
-					modlogger.Info("iconfinder.com apikey not set: in order to use online icons please enter a icon finder api key")
-					useIconFinder = false
-				} else if len(cfgDSP.IconFinderDotComAPIKey) > 0 && !strings.EqualFold(cfgDSP.IconFinderDotComAPIKey, "example") {
-					icofdrapi.ChangeAPIKey(cfgDSP.IconFinderDotComAPIKey)
-					useIconFinder = true
-				} else {
-					useIconFinder = false
-					modlogger.Info("iconfinder.com apikey not set: in order to use online icons please enter a icon finder api key")
-				}
-
 				sessionMap = d.GetSessionMap()
 				sliderMap = d.GetSliderMap()
 				loadDSPMapings(modlogger)
@@ -308,52 +448,131 @@ func loadDSPMapings(modlogger *zap.SugaredLogger) {
 	AutoMap := deejdsp.CreateAutoMap(sliderMap, sessionMap)
 	modlogger.Debugf("AutoMaped Sessions: %v", AutoMap)
 	sdfiles, _ := serSD.ListDir()
+	// the firmware says why it could not show a file, like a .br file made for another display size
+	show := func(key int, filename string, profile deejdsp.DisplayProfile) {
+		if err := showImage(filename, profile); err != nil {
+			modlogger.Errorf("%d: could not show %q: %s", key, filename, err.Error())
+		}
+	}
 	//for each screen go and check the config and finaly set the image
 	for key, value := range cfgDSP.DisplayMapping {
 		serTCA.SelectPort(uint8(key))
-		if value != "auto" { // Set to name in the customised image
+		// the firmware assumes 128x64 so only send the geometry when it changes
+		profile := cfgDSP.DisplayProfile(key)
+		// colour displays are not driven through the ssd1306 commands
+		if current, ok := crntDSPgeometry[key]; !profile.Controller.Color() && ((ok && current != profile) || (!ok && !profile.IsDefault())) {
+			if err := serDSP.SetGeometry(profile); err != nil {
+				modlogger.Errorf("%d: could not set display geometry: %s", key, err.Error())
+			} else {
+				crntDSPgeometry[key] = profile
+				// the image on the display was drawn for the old geometry
+				delete(crntDSPimg, key)
+			}
+		}
+		if len(value) <= 0 { // Turn the display off if nothing is set
+			displayOff(profile)
+			delete(crntDSPimg, key)
+		} else if value != "auto" { // Set to name in the customised image
 			if value != crntDSPimg[key] {
 				fileExsists, _ := serSD.CheckForFileLOAD(value, sdfiles)
 				if fileExsists {
-					serDSP.SetImage(string(value))
+					show(key, string(value), profile)
 					modlogger.Debugf("%d: %q", key, value)
 					crntDSPimg[key] = value
 				} else {
 					modlogger.Debugf("%d: imagefile with name %q does not exsist on remote", key, value)
 				}
 			}
-			serDSP.DisplayOn()
-		} else if len(value) <= 0 { // Turn the display off if nothing is set
-			serDSP.DisplayOff()
+			displayOn(profile)
 		} else if value == "auto" { // if its set to auto: Generate a image if it does not exsist and send it to the SD card
 			//get the audio session from deej using the AutoMap
 			if autoMappedImage, ok := AutoMap[key]; ok {
 				programname := strings.Split(autoMappedImage, ".")[0]
-				sdname := deejdsp.CreateFileName(programname)
+				// displays with their own transform or pipeline get their own generated file
+				convertOptions := cfgDSP.DisplayConvertOptions(key, programname)
+				sdname := deejdsp.CreateVariantFileName(programname, convertOptions.VariantKey())
+				// colour displays use .c files
+				extension := ".b"
+				if profile.Controller.Color() {
+					sdname = deejdsp.CreateColorFileName(programname, convertOptions.VariantKey())
+					extension = ".c"
+				}
 
 				// Check if the file exsits on the card
 				pregenerated, _ := serSD.CheckForFileLOAD(sdname, sdfiles)
-				customImage, _ := serSD.CheckForFileLOAD(programname+".b", sdfiles)
+				compress := !profile.Controller.Color() && dspFeatures.Has(deejdsp.FeatureRLE)
+				compressedName := deejdsp.CreateCompressedFileName(programname, convertOptions.VariantKey())
+				if compress {
+					if compressed, _ := serSD.CheckForFileLOAD(compressedName, sdfiles); compressed {
+						pregenerated = true
+						sdname = compressedName
+					}
+				}
+				customName := programname + extension
+				customImage, _ := serSD.CheckForFileLOAD(customName, sdfiles)
 				if customImage == false {
-					customImage, _ = serSD.CheckForFileLOAD(strings.ToLower(programname)+".b", sdfiles)
+					customImage, _ = serSD.CheckForFileLOAD(strings.ToLower(programname)+extension, sdfiles)
+				}
+				// animations sent from the tray menu are used over still images
+				if !profile.Controller.Color() && dspFeatures.Has(deejdsp.FeatureAnimation) {
+					animationName := deejdsp.CreateAnimationFileName(programname, convertOptions.VariantKey())
+					if customAnimation, _ := serSD.CheckForFileLOAD(animationName, sdfiles); customAnimation {
+						customImage = true
+						customName = animationName
+					}
 				}
 				// generate a new image if it doesnt exsist
-				if !pregenerated && useIconFinder && !customImage {
-					//Get Icon from API and convert it to byteslices
-					qualifiedico, err := deejdsp.GetIconFromAPI(icofdrapi, programname)
-					if err != nil {
-						modlogger.Named("Display").Errorf("Could not get image from API, try generating your own image insted for %s: Error Text %s", programname, err.Error())
-					} else {
-						slicedIMG, err := deejdsp.ConvertImage(qualifiedico, 0, cfgDSP.BWThreshold)
+				if !pregenerated && !customImage {
+					var iconImage image.Image
+					iconOptions := convertOptions
+					if len(cfgDSP.IconProviders) > 0 {
+						//Get Icon from the icon providers
+						icon, err := cfgDSP.IconProviders.Lookup(autoMappedImage, convertOptions.Profile)
 						if err != nil {
-							modlogger.Errorf("No Image found in qualifiedico")
+							modlogger.Named("Display").Errorf("Could not find an icon, try generating your own image insted for %s: Error Text %s", programname, err.Error())
+						} else {
+							modlogger.Named("Display").Debugf("Using icon for %s from %s: %s", programname, icon.Provider, icon.Source)
+							iconImage = icon.Image
+						}
+					}
+					// draw a placeholder so the display still shows which program it controls
+					if iconImage == nil && cfgDSP.Placeholder != nil {
+						// placeholders get their own file so an icon found later is not hidden by one
+						variant := "placeholder-" + cfgDSP.Placeholder.Key() + convertOptions.VariantKey()
+						sdname = deejdsp.CreateVariantFileName(programname, variant)
+						if profile.Controller.Color() {
+							sdname = deejdsp.CreateColorFileName(programname, variant)
+						}
+						compressedName = deejdsp.CreateCompressedFileName(programname, variant)
+						drawn, _ := serSD.CheckForFileLOAD(sdname, sdfiles)
+						if !drawn && compress {
+							if drawn, _ = serSD.CheckForFileLOAD(compressedName, sdfiles); drawn {
+								sdname = compressedName
+							}
+						}
+						if drawn {
+							if crntDSPimg[key] != sdname {
+								crntDSPimg[key] = sdname
+								show(key, sdname, profile)
+								modlogger.Debugf("%d: program %q placeholder %q", key, programname, sdname)
+							}
+						} else {
+							modlogger.Debugf("Drawing a placeholder for %s", programname)
+							iconImage, iconOptions = deejdsp.GeneratePlaceholder(autoMappedImage, convertOptions, *cfgDSP.Placeholder)
+						}
+					}
+					if iconImage != nil {
+						// convert the icon to byteslices
+						byteslice, err := convertIcon(iconImage, iconOptions)
+						if err != nil {
+							modlogger.Errorf("Could not convert the image for %s: %s", programname, err.Error())
 							break
 						}
-						// Convert to a single long slice
-						var byteslice []byte
-						for _, value := range slicedIMG {
-							for _, value2 := range value {
-								byteslice = append(byteslice, value2)
+						// only use the compressed file if it is actually smaller
+						if compress {
+							if compressed, err := bimage.Compress(byteslice, profile.Geometry()); err == nil && len(compressed) < len(byteslice) {
+								byteslice = compressed
+								sdname = compressedName
 							}
 						}
 						// Send Slice to the SD card
@@ -361,28 +580,270 @@ func loadDSPMapings(modlogger *zap.SugaredLogger) {
 						sdfiles = append(sdfiles, sdname)
 						// Store the current mapping
 						crntDSPimg[key] = sdname
-						serDSP.SetImage(sdname)
+						show(key, sdname, profile)
 						modlogger.Debugf("%d: program %q localfile %q", key, programname, sdname)
 					}
 				} else {
 					if customImage {
-						if crntDSPimg[key] != programname+".b" {
-							crntDSPimg[key] = programname + ".b"
-							serDSP.SetImage(programname + ".b")
-							modlogger.Debugf("%d: program %q localfile %q", key, programname, programname+".b")
+						if crntDSPimg[key] != customName {
+							crntDSPimg[key] = customName
+							show(key, customName, profile)
+							modlogger.Debugf("%d: program %q localfile %q", key, programname, customName)
 						}
 					} else {
 						if crntDSPimg[key] != sdname {
 							crntDSPimg[key] = sdname
-							serDSP.SetImage(sdname)
+							show(key, sdname, profile)
 							modlogger.Debugf("%d: program %q localfile %q", key, programname, sdname)
 						}
 					}
 				}
-				serDSP.DisplayOn()
+				displayOn(profile)
+			} else {
+				displayOff(profile)
+			}
+		}
+	}
+}
+
+// showImage shows a file from the sd card on the selected display, animation files are played
+// and compressed images are expanded by the firmware
+// The firmware has no driver for colour displays so their .c files are only kept on the card
+func showImage(filename string, profile deejdsp.DisplayProfile) error {
+	if profile.Controller.Color() {
+		return nil
+	}
+	layout := profile.Layout
+	switch {
+	case strings.EqualFold(filepath.Ext(filename), animationExtension):
+		if !dspFeatures.Has(deejdsp.FeatureAnimation) {
+			return fmt.Errorf("the firmware does not support animations, can not play %s", filename)
+		}
+		return serDSP.PlayAnimationWithLayout(filename, layout)
+	case strings.EqualFold(filepath.Ext(filename), compressedExtension):
+		return serDSP.SetCompressedImageWithLayout(filename, layout)
+	}
+	return serDSP.SetImageWithLayout(filename, layout)
+}
+
+// displayOn turns on the selected display, colour displays are left alone as the firmware can not drive them
+func displayOn(profile deejdsp.DisplayProfile) error {
+	if profile.Controller.Color() {
+		return nil
+	}
+	return serDSP.DisplayOn()
+}
+
+// displayOff turns off the selected display, colour displays are left alone as the firmware can not drive them
+func displayOff(profile deejdsp.DisplayProfile) error {
+	if profile.Controller.Color() {
+		return nil
+	}
+	return serDSP.DisplayOff()
+}
+
+// sendAnimation converts a gif or apng for every black and white display and sends it to the sd card
+// The file is named after the image so a display_mapping or program with that name plays it,
+// displays with their own transform or pipeline get their own copy named with CreateAnimationFileName
+func sendAnimation(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	anim, err := deejdsp.DecodeAnimation(f)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+
+	var displays []int
+	for key := range cfgDSP.DisplayMapping {
+		if !cfgDSP.DisplayProfile(key).Controller.Color() {
+			displays = append(displays, key)
+		}
+	}
+	sort.Ints(displays)
+
+	var sdFilenames []string
+	sent := make(map[string]bool)
+	send := func(opts deejdsp.ConvertOptions) error {
+		sdFilename := deejdsp.CreateAnimationFileName(name, opts.VariantKey())
+		if sent[sdFilename] {
+			return nil
+		}
+		seq, err := deejdsp.ConvertAnimation(anim, opts)
+		if err != nil {
+			return err
+		}
+		data, err := bimage.MarshalSequence(seq)
+		if err != nil {
+			return err
+		}
+		serSD.SendByteSlice(data, sdFilename)
+		sent[sdFilename] = true
+		sdFilenames = append(sdFilenames, sdFilename)
+		return nil
+	}
+	// without any displays configured the default profile is used
+	if len(displays) == 0 {
+		return sdFilenames, send(cfgDSP.ImageOptions)
+	}
+	for _, key := range displays {
+		if err := send(cfgDSP.DisplayConvertOptions(key, name)); err != nil {
+			return sdFilenames, err
+		}
+	}
+	return sdFilenames, nil
+}
+
+// convertIcon converts an icon into the contents of the file for the display described by opts
+func convertIcon(icon image.Image, opts deejdsp.ConvertOptions) ([]byte, error) {
+	if opts.Profile.Controller.Color() {
+		return deejdsp.ConvertColorImage(icon, opts)
+	}
+	slicedIMG, err := deejdsp.ConvertImageWithOptions(icon, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Convert to a single long slice
+	return bimage.JoinPages(slicedIMG)
+}
+
+// trayItem is the part of a tray menu item used to hide files that are no longer on the card
+type trayItem interface {
+	Show()
+	Hide()
+}
+
+// isDisplayFile reports if a file on the sd card is one of the image files shown on the displays
+func isDisplayFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".b", ".c", compressedExtension, animationExtension:
+		return true
+	}
+	return false
+}
+
+// savePreview asks where to save a png preview of an image file for the displays
+func savePreview(filename string, data []byte) {
+	img, err := decodeDisplayFile(filename, data, bimage.Geometry{})
+	if err != nil {
+		dialog.Message("%s", err.Error()).Title("Preview Image").Error()
+		return
+	}
+	pngFilename, err := dialog.File().Filter("PNG Image", "png").Title("Save Preview").Save()
+	if err != nil {
+		return
+	}
+	// colour images are already what the display shows
+	if !strings.EqualFold(filepath.Ext(filename), ".c") {
+		img = bimage.Preview(img, bimage.DefaultPreviewScale)
+	}
+	if err = writePNG(pngFilename, img); err != nil {
+		dialog.Message("%s", err.Error()).Title("Save Preview").Error()
+	}
+}
+
+// createContactSheet reads the current image of every display back from the sd card and lays them out in one picture
+func createContactSheet(modlogger *zap.SugaredLogger) image.Image {
+	modlogger = modlogger.Named("Display")
+
+	var displays []int
+	for key := range cfgDSP.DisplayMapping {
+		displays = append(displays, key)
+	}
+	sort.Ints(displays)
+
+	var entries []bimage.SheetEntry
+	for _, key := range displays {
+		filename := crntDSPimg[key]
+		if filename == "" && cfgDSP.DisplayMapping[key] != "auto" {
+			filename = cfgDSP.DisplayMapping[key]
+		}
+		entry := bimage.SheetEntry{Label: fmt.Sprintf("%d: %s", key, filename)}
+		if filename != "" {
+			data, err := serSD.ReadFile(filename)
+			if err != nil {
+				modlogger.Warnf("Could not read %q back from the card: %s", filename, err.Error())
+			} else if img, err := decodeDisplayFile(filename, data, cfgDSP.DisplayProfile(key).Geometry()); err != nil {
+				modlogger.Warnf("%q is not a valid image file: %s", filename, err.Error())
 			} else {
-				serDSP.DisplayOff()
+				entry.Image = img
 			}
 		}
+		entries = append(entries, entry)
+	}
+
+	return bimage.ContactSheet(entries, 2, 2)
+}
+
+// decodeDisplayFile decodes a file read back from the sd card using its extension
+// Animations show their first frame
+// .b files have no header, geometry is the display they were made for, the zero value guesses it with byteImageGeometry
+func decodeDisplayFile(filename string, data []byte, geometry bimage.Geometry) (image.Image, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case compressedExtension:
+		expanded, geometry, err := bimage.Decompress(data)
+		if err != nil {
+			return nil, err
+		}
+		return bimage.UnmarshalGeometry(expanded, geometry)
+	case animationExtension:
+		seq, err := bimage.UnmarshalSequence(data)
+		if err != nil {
+			return nil, err
+		}
+		return bimage.UnmarshalGeometry(seq.Frames[0].Data, seq.Geometry)
+	case ".c":
+		return cimage.Unmarshal(data)
+	}
+	if geometry.Width == 0 && geometry.Height == 0 {
+		var err error
+		if geometry, err = byteImageGeometry(len(data)); err != nil {
+			return nil, err
+		}
+	}
+	return bimage.UnmarshalGeometry(data, geometry)
+}
+
+// byteImageGeometry works out the display a .b file of size bytes was made for
+// The configured black and white displays are checked before the known panel sizes,
+// so a 64x64 display is not mistaken for a 128x32 one with the same number of bytes
+func byteImageGeometry(size int) (bimage.Geometry, error) {
+	var displays []int
+	for key := range cfgDSP.DisplayMapping {
+		displays = append(displays, key)
+	}
+	sort.Ints(displays)
+	for _, key := range displays {
+		profile := cfgDSP.DisplayProfile(key)
+		if !profile.Controller.Color() && profile.Geometry().Size() == size {
+			return profile.Geometry(), nil
+		}
+	}
+	return bimage.GeometryForSize(size)
+}
+
+// validateByteImageFile checks that a .b file fits one of the configured displays or a known panel
+func validateByteImageFile(filename string) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	_, err = byteImageGeometry(int(info.Size()))
+	return err
+}
+
+// writePNG saves img as a png file
+func writePNG(filename string, img image.Image) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = png.Encode(f, img); err != nil {
+		f.Close()
+		return err
 	}
+	return f.Close()
 }
@@ -3,8 +3,11 @@ package deejdsp
 // Pretty much ripped from origonal deej repo with changes to suit the displays
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"strings"
+	"time"
 
 	"github.com/jax-b/deej/pkg/deej/util"
 	"go.uber.org/zap"
@@ -14,23 +17,82 @@ import (
 // DSPCanonicalConfig config of DSP
 type DSPCanonicalConfig struct {
 	DisplayMapping         map[int]string
+	DisplaySettings        map[int]DisplaySettings
 	StartupDelay           int
 	logger                 *zap.SugaredLogger
 	CommandDelay           int
 	BWThreshold            int
+	ImageOptions           ConvertOptions
+	ProcessPipelines       map[string]Pipeline
 	IconFinderDotComAPIKey string
+	// IconProviders is where icons for auto displays are looked up, in order
+	IconProviders IconProviderChain
+	// IconProviderNotices are the reasons providers in icon_providers were left out of IconProviders
+	IconProviderNotices []string
+	// IconCache keeps icons from online providers, nil if it is turned off
+	IconCache *IconCache
+	// Placeholder is drawn for auto displays when no icon can be found, nil if it is turned off
+	Placeholder *PlaceholderOptions
+}
+
+// DisplaySettings holds the extra per display options that can be set in display_mapping
+type DisplaySettings struct {
+	Transform ImageTransform
+	Pipeline  Pipeline
+	// Mode and StrokeWidth are only used when HasMode is set, otherwise image_options is used
+	// A StrokeWidth of 0 uses the stroke width from image_options
+	HasMode     bool
+	Mode        ConvertMode
+	StrokeWidth int
+	Profile     DisplayProfile
 }
 
 type marshalledConfig struct {
-	DisplayMapping         map[int]interface{} `yaml:"display_mapping"`
-	StartupDelay           int                 `yaml:"startup_delay"`
-	CommandDelay           int                 `yaml:"command_delay"`
-	BWThreshold            int                 `yaml:"BlackWhite_Threshold"`
-	IconFinderDotComAPIKey string              `yaml:"IconFinderDotComAPIKey"`
+	DisplayMapping         map[int]interface{}      `yaml:"display_mapping"`
+	StartupDelay           int                      `yaml:"startup_delay"`
+	CommandDelay           int                      `yaml:"command_delay"`
+	BWThreshold            interface{}              `yaml:"BlackWhite_Threshold"`
+	ImageOptions           marshalledImageOptions   `yaml:"image_options"`
+	ProcessPipelines       map[string][]interface{} `yaml:"process_pipelines"`
+	IconFinderDotComAPIKey string                   `yaml:"IconFinderDotComAPIKey"`
+	IconProviders          []interface{}            `yaml:"icon_providers"`
+	IconCache              marshalledIconCache      `yaml:"icon_cache"`
+	Placeholder            marshalledPlaceholder    `yaml:"placeholder"`
+}
+
+type marshalledIconCache struct {
+	Enabled    *bool  `yaml:"enabled"`
+	Path       string `yaml:"path"`
+	TTL        string `yaml:"ttl"`
+	MissTTL    string `yaml:"miss_ttl"`
+	MaxEntries int    `yaml:"max_entries"`
+}
+
+type marshalledPlaceholder struct {
+	Enabled *bool  `yaml:"enabled"`
+	Text    string `yaml:"text"`
+	Frame   *bool  `yaml:"frame"`
+}
+
+type marshalledImageOptions struct {
+	Resample        string        `yaml:"resample"`
+	Scale           string        `yaml:"scale"`
+	AlignHorizontal string        `yaml:"align_horizontal"`
+	AlignVertical   string        `yaml:"align_vertical"`
+	Margins         Margins       `yaml:"margins"`
+	Background      string        `yaml:"background"`
+	Trim            string        `yaml:"trim"`
+	TrimTolerance   int           `yaml:"trim_tolerance"`
+	Pipeline        []interface{} `yaml:"pipeline"`
+	Mode            string        `yaml:"mode"`
+	StrokeWidth     int           `yaml:"stroke_width"`
 }
 
 const configFilepath = "config.yaml"
 
+// defaultIconProviders is used when icon_providers is not in the config
+var defaultIconProviders = []interface{}{"iconfinder"}
+
 // NewDSPConfig creates the config object
 func NewDSPConfig(logger *zap.SugaredLogger) (*DSPCanonicalConfig, error) {
 	logger = logger.Named("config")
@@ -75,7 +137,7 @@ func (cc *DSPCanonicalConfig) Load() error {
 
 	cc.logger.Info("Loaded config successfully")
 	cc.logger.Infow("Config values",
-		"DisplayMapping", cc.DisplayMapping, "StartupDelay", cc.StartupDelay, "CommandDelay", cc.CommandDelay, "BWThreshold", cc.BWThreshold)
+		"DisplayMapping", cc.DisplayMapping, "StartupDelay", cc.StartupDelay, "CommandDelay", cc.CommandDelay, "BWThreshold", cc.BWThreshold, "ImageOptions", cc.ImageOptions, "IconProviders", cc.IconProviders.Names())
 
 	return nil
 }
@@ -89,10 +151,12 @@ func (cc *DSPCanonicalConfig) populateFromMarshalled(mc *marshalledConfig) error
 			"value", map[int]string{0: ""})
 
 		cc.DisplayMapping = map[int]string{0: ""}
+		cc.DisplaySettings = make(map[int]DisplaySettings)
 	} else {
 		cc.DisplayMapping = make(map[int]string)
+		cc.DisplaySettings = make(map[int]DisplaySettings)
 		// this is where we need to parse out each value (which is an interface{} at this point),
-		// and type-assert it into either a string or a list of strings
+		// and type-assert it into either a string or a map of display settings
 		for key, value := range mc.DisplayMapping {
 			switch typedValue := value.(type) {
 			case string:
@@ -102,6 +166,25 @@ func (cc *DSPCanonicalConfig) populateFromMarshalled(mc *marshalledConfig) error
 					cc.DisplayMapping[key] = typedValue
 				}
 
+			// a map holds the image name along with the settings for that display
+			case map[string]interface{}:
+				image, settings, err := parseDisplaySettings(typedValue)
+				if err != nil {
+					cc.logger.Warnw("Invalid settings for display mapping key",
+						"key", key,
+						"error", err)
+
+					return fmt.Errorf("invalid display settings for display %d: %w", key, err)
+				}
+				// an entry without an image turns the display off like an empty one
+				cc.DisplayMapping[key] = image
+				cc.DisplaySettings[key] = settings
+				if settings.Profile.Controller.Color() {
+					cc.logger.Warnw("The firmware can not show images on colour displays yet, their .c files are only sent to the sd card",
+						"key", key,
+						"controller", settings.Profile.Controller.String())
+				}
+
 			// silently ignore nil values and treat as no targets
 			case nil:
 				cc.DisplayMapping[key] = ""
@@ -134,22 +217,380 @@ func (cc *DSPCanonicalConfig) populateFromMarshalled(mc *marshalledConfig) error
 		cc.CommandDelay = mc.CommandDelay
 	}
 
-	if mc.BWThreshold <= 0 {
+	switch typedValue := mc.BWThreshold.(type) {
+	case int:
+		if typedValue <= 0 {
+			cc.logger.Warnw("Missing key in config, using default value",
+				"key", "BlackWhite_Threshold",
+				"value", typedValue)
+			cc.BWThreshold = 200
+		} else {
+			cc.BWThreshold = typedValue
+		}
+	case string:
+		// auto and adaptive let the image pick its own threshold
+		switch strings.ToLower(typedValue) {
+		case "auto", "otsu":
+			cc.BWThreshold = ThresholdOtsu
+		case "adaptive":
+			cc.BWThreshold = ThresholdAdaptive
+		default:
+			cc.logger.Warnw("Invalid value for threshold key, using default value",
+				"key", "BlackWhite_Threshold",
+				"value", typedValue)
+			cc.BWThreshold = 200
+		}
+	default:
 		cc.logger.Warnw("Missing key in config, using default value",
 			"key", "BlackWhite_Threshold",
-			"value", mc.BWThreshold)
+			"value", typedValue)
 		cc.BWThreshold = 200
-	} else {
-		cc.BWThreshold = mc.BWThreshold
 	}
 
-	if mc.IconFinderDotComAPIKey == "" {
-		cc.logger.Warnw("Missing key in config, cannot grab icons",
-			"key", "iconFinderDotComAPIKey")
-		cc.IconFinderDotComAPIKey = ""
-	} else {
-		cc.IconFinderDotComAPIKey = mc.IconFinderDotComAPIKey
+	cc.ImageOptions = DefaultConvertOptions()
+	cc.ImageOptions.Threshold = cc.BWThreshold
+	cc.ImageOptions.Margins = mc.ImageOptions.Margins
+	if mc.ImageOptions.Resample != "" {
+		filter, err := ParseResampleFilter(mc.ImageOptions.Resample)
+		if err != nil {
+			cc.logger.Warnw("Invalid value for image option, using default value", "key", "resample", "error", err)
+		}
+		cc.ImageOptions.Filter = filter
+	}
+	if mc.ImageOptions.Scale != "" {
+		scale, err := ParseScaleMode(mc.ImageOptions.Scale)
+		if err != nil {
+			cc.logger.Warnw("Invalid value for image option, using default value", "key", "scale", "error", err)
+		}
+		cc.ImageOptions.Scale = scale
+	}
+	if mc.ImageOptions.AlignHorizontal != "" {
+		align, err := ParseAlignment(mc.ImageOptions.AlignHorizontal)
+		if err != nil {
+			cc.logger.Warnw("Invalid value for image option, using default value", "key", "align_horizontal", "error", err)
+		}
+		cc.ImageOptions.HAlign = align
+	}
+	if mc.ImageOptions.AlignVertical != "" {
+		align, err := ParseAlignment(mc.ImageOptions.AlignVertical)
+		if err != nil {
+			cc.logger.Warnw("Invalid value for image option, using default value", "key", "align_vertical", "error", err)
+			align = AlignStart
+		}
+		cc.ImageOptions.VAlign = align
+	}
+	if mc.ImageOptions.Background != "" {
+		background, err := ParseBackground(mc.ImageOptions.Background)
+		if err != nil {
+			cc.logger.Warnw("Invalid value for image option, using default value", "key", "background", "error", err)
+		}
+		cc.ImageOptions.Background = background
+	}
+	if mc.ImageOptions.Trim != "" {
+		trim, err := ParseTrimMode(mc.ImageOptions.Trim)
+		if err != nil {
+			cc.logger.Warnw("Invalid value for image option, using default value", "key", "trim", "error", err)
+		}
+		cc.ImageOptions.Trim = trim
+	}
+	if mc.ImageOptions.TrimTolerance > 0 {
+		cc.ImageOptions.TrimTolerance = mc.ImageOptions.TrimTolerance
+	}
+	if mc.ImageOptions.Pipeline != nil {
+		pipeline, err := ParsePipeline(mc.ImageOptions.Pipeline)
+		if err != nil {
+			cc.logger.Warnw("Invalid image pipeline", "key", "pipeline", "error", err)
+			return fmt.Errorf("invalid image pipeline: %w", err)
+		}
+		cc.ImageOptions.Pipeline = pipeline
+	}
+
+	if mc.ImageOptions.Mode != "" {
+		mode, err := ParseConvertMode(mc.ImageOptions.Mode)
+		if err != nil {
+			cc.logger.Warnw("Invalid value for image option, using default value", "key", "mode", "error", err)
+		}
+		cc.ImageOptions.Mode = mode
+	}
+	if mc.ImageOptions.StrokeWidth > 0 {
+		cc.ImageOptions.StrokeWidth = mc.ImageOptions.StrokeWidth
+	}
+
+	cc.ProcessPipelines = make(map[string]Pipeline)
+	for process, spec := range mc.ProcessPipelines {
+		pipeline, err := ParsePipeline(spec)
+		if err != nil {
+			cc.logger.Warnw("Invalid image pipeline for process", "process", process, "error", err)
+			return fmt.Errorf("invalid image pipeline for process %s: %w", process, err)
+		}
+		cc.ProcessPipelines[processKey(process)] = pipeline
+	}
+
+	cc.IconFinderDotComAPIKey = mc.IconFinderDotComAPIKey
+
+	providerSpec := mc.IconProviders
+	if providerSpec == nil {
+		cc.logger.Debugw("Missing key in config, using default value",
+			"key", "icon_providers",
+			"value", defaultIconProviders)
+		providerSpec = defaultIconProviders
+	}
+	// the top level api key is used by iconfinder entries that do not have their own
+	providerDefaults := map[string]map[string]interface{}{
+		"iconfinder": {"api_key": mc.IconFinderDotComAPIKey},
+	}
+	providers, skipped, err := ParseIconProviders(providerSpec, providerDefaults)
+	if err != nil {
+		cc.logger.Warnw("Invalid icon providers", "key", "icon_providers", "error", err)
+		return fmt.Errorf("invalid icon providers: %w", err)
+	}
+	cc.IconProviders = providers
+	cc.IconProviderNotices = nil
+	for _, reason := range skipped {
+		if errors.Is(reason, ErrIconProviderSilenced) {
+			cc.logger.Debugw("Icon provider disabled", "reason", reason)
+			continue
+		}
+		cc.logger.Warnw("Icon provider disabled", "reason", reason)
+		cc.IconProviderNotices = append(cc.IconProviderNotices, reason.Error())
+	}
+
+	cc.IconCache = nil
+	if mc.IconCache.Enabled == nil || *mc.IconCache.Enabled {
+		cache, err := newIconCacheFromConfig(mc.IconCache)
+		if err != nil {
+			// the icons can still be looked up, they just are not kept
+			cc.logger.Warnw("Could not set up the icon cache, icons will not be cached", "key", "icon_cache", "error", err)
+		} else {
+			if err := cache.Prune(); err != nil {
+				cc.logger.Warnw("Could not clean up the icon cache", "path", cache.Path, "error", err)
+			}
+			cc.IconCache = cache
+			cc.IconProviders = cache.WrapRemote(cc.IconProviders)
+		}
+	}
+
+	cc.Placeholder = nil
+	if mc.Placeholder.Enabled == nil || *mc.Placeholder.Enabled {
+		placeholder := DefaultPlaceholderOptions()
+		if mc.Placeholder.Text != "" {
+			text, err := ParsePlaceholderText(mc.Placeholder.Text)
+			if err != nil {
+				cc.logger.Warnw("Invalid value for placeholder option, using default value", "key", "text", "error", err)
+			}
+			placeholder.Text = text
+		}
+		if mc.Placeholder.Frame != nil {
+			placeholder.Frame = *mc.Placeholder.Frame
+		}
+		cc.Placeholder = &placeholder
 	}
 
 	return nil
 }
+
+// newIconCacheFromConfig creates the icon cache from the icon_cache settings
+func newIconCacheFromConfig(mc marshalledIconCache) (*IconCache, error) {
+	cache, err := NewIconCache(mc.Path)
+	if err != nil {
+		return nil, err
+	}
+	if mc.TTL != "" {
+		ttl, err := time.ParseDuration(mc.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("ttl: %w", err)
+		}
+		cache.TTL = ttl
+	}
+	if mc.MissTTL != "" {
+		ttl, err := time.ParseDuration(mc.MissTTL)
+		if err != nil {
+			return nil, fmt.Errorf("miss_ttl: %w", err)
+		}
+		cache.MissTTL = ttl
+	}
+	if mc.MaxEntries > 0 {
+		cache.MaxEntries = mc.MaxEntries
+	}
+	return cache, nil
+}
+
+// DisplayConvertOptions returns the image options for a process shown on a display
+// A pipeline set for the process wins over one set for the display, which wins over image_options
+func (cc *DSPCanonicalConfig) DisplayConvertOptions(display int, process string) ConvertOptions {
+	opts := cc.ImageOptions
+	if settings, ok := cc.DisplaySettings[display]; ok {
+		opts.Transform = settings.Transform
+		if settings.Pipeline != nil {
+			opts.Pipeline = settings.Pipeline
+		}
+		if settings.HasMode {
+			opts.Mode = settings.Mode
+			if settings.StrokeWidth > 0 {
+				opts.StrokeWidth = settings.StrokeWidth
+			}
+		}
+		opts.Profile = settings.Profile
+	}
+	if pipeline, ok := cc.ProcessPipelines[processKey(process)]; ok {
+		opts.Pipeline = pipeline
+	}
+	return opts
+}
+
+// DisplayProfile returns the panel profile for a display, displays without one use DefaultDisplayProfile
+func (cc *DSPCanonicalConfig) DisplayProfile(display int) DisplayProfile {
+	if settings, ok := cc.DisplaySettings[display]; ok {
+		return settings.Profile
+	}
+	return DefaultDisplayProfile()
+}
+
+// processKey normalises a process name so spotify.exe and Spotify match
+func processKey(process string) string {
+	return strings.ToLower(strings.Split(process, ".")[0])
+}
+
+// parseDisplaySettings type-asserts a display mapping entry written as a map
+// it returns the image name and the rest of the settings
+func parseDisplaySettings(values map[string]interface{}) (string, DisplaySettings, error) {
+	var image string
+	var settings DisplaySettings
+	settings.Profile = DefaultDisplayProfile()
+	// the controller sets the defaults for the rest of the profile so it has to be read first
+	for key, value := range values {
+		if !strings.EqualFold(key, "controller") {
+			continue
+		}
+		controllerName, ok := value.(string)
+		if !ok {
+			return "", settings, fmt.Errorf("controller: got type %T, need string", value)
+		}
+		controller, err := ParseController(controllerName)
+		if err != nil {
+			return "", settings, err
+		}
+		settings.Profile = ControllerProfile(controller)
+	}
+	for key, value := range values {
+		switch strings.ToLower(key) {
+		case "controller":
+			continue
+		case "image":
+			if value == nil {
+				continue
+			}
+			name, ok := value.(string)
+			if !ok {
+				return "", settings, fmt.Errorf("image: got type %T, need string", value)
+			}
+			image = name
+		case "invert":
+			invert, ok := value.(bool)
+			if !ok {
+				return "", settings, fmt.Errorf("invert: got type %T, need bool", value)
+			}
+			settings.Transform.Invert = invert
+		case "rotate":
+			degrees, ok := value.(int)
+			if !ok {
+				return "", settings, fmt.Errorf("rotate: got type %T, need int", value)
+			}
+			rotation, err := ParseRotation(degrees)
+			if err != nil {
+				return "", settings, err
+			}
+			settings.Transform.Rotate = rotation
+		case "pipeline":
+			spec, ok := value.([]interface{})
+			if !ok {
+				return "", settings, fmt.Errorf("pipeline: got type %T, need list", value)
+			}
+			pipeline, err := ParsePipeline(spec)
+			if err != nil {
+				return "", settings, err
+			}
+			settings.Pipeline = pipeline
+		case "mode":
+			modeName, ok := value.(string)
+			if !ok {
+				return "", settings, fmt.Errorf("mode: got type %T, need string", value)
+			}
+			mode, err := ParseConvertMode(modeName)
+			if err != nil {
+				return "", settings, err
+			}
+			settings.HasMode = true
+			settings.Mode = mode
+		case "stroke_width":
+			stroke, ok := value.(int)
+			if !ok || stroke < 1 {
+				return "", settings, fmt.Errorf("stroke_width: need a whole number of 1 or more, got %v", value)
+			}
+			settings.StrokeWidth = stroke
+		case "profile", "size":
+			size, ok := value.(string)
+			if !ok {
+				return "", settings, fmt.Errorf("%s: got type %T, need WIDTHxHEIGHT", key, value)
+			}
+			width, height, err := ParseDisplaySize(size)
+			if err != nil {
+				return "", settings, err
+			}
+			settings.Profile.Width = width
+			settings.Profile.Height = height
+		case "palette":
+			colors, ok := value.(int)
+			if !ok {
+				return "", settings, fmt.Errorf("palette: got type %T, need a whole number", value)
+			}
+			settings.Profile.Palette = colors
+		case "width", "height", "column_offset":
+			number, ok := value.(int)
+			if !ok {
+				return "", settings, fmt.Errorf("%s: got type %T, need a whole number", key, value)
+			}
+			switch strings.ToLower(key) {
+			case "width":
+				settings.Profile.Width = number
+			case "height":
+				settings.Profile.Height = number
+			default:
+				settings.Profile.ColumnOffset = number
+			}
+		case "layout":
+			layoutName, ok := value.(string)
+			if !ok {
+				return "", settings, fmt.Errorf("layout: got type %T, need string", value)
+			}
+			layout, err := ParsePageLayout(layoutName)
+			if err != nil {
+				return "", settings, err
+			}
+			settings.Profile.Layout = layout
+		case "flip":
+			flip, ok := value.(string)
+			if !ok {
+				return "", settings, fmt.Errorf("flip: got type %T, need string", value)
+			}
+			horizontal, vertical, err := ParseFlip(flip)
+			if err != nil {
+				return "", settings, err
+			}
+			settings.Transform.FlipH = horizontal
+			settings.Transform.FlipV = vertical
+		default:
+			return "", settings, fmt.Errorf("unknown display setting %q", key)
+		}
+	}
+	if err := settings.Profile.Validate(); err != nil {
+		return "", settings, err
+	}
+	// a stroke width of 0 uses the one from image_options
+	if settings.StrokeWidth > 0 && !settings.HasMode {
+		// giving a stroke width on its own only makes sense for outlines
+		settings.HasMode = true
+		settings.Mode = ModeOutline
+	}
+	return image, settings, nil
+}
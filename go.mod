@@ -8,9 +8,11 @@ require (
 	github.com/jax-b/ssd1306FilePrep v0.1.1
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/sqweek/dialog v0.0.0-20200911184034-8a3d98e8211d
+	github.com/srwiley/oksvg v0.0.0-20200311192757-870daf9aa564
+	github.com/srwiley/rasterx v0.0.0-20200120212402-85cb7272f5e9
 	go.uber.org/zap v1.16.0
+	golang.org/x/image v0.0.0-20201208152932-35266b937fa6
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
-
 )
 
 // replace github.com/jax-b/deej v0.9.10 => ../deej
@@ -0,0 +1,181 @@
+#!/usr/bin/env python3
+# Generates the windows executables used by ImageExeIcon_test.go
+# Run from the testdata directory: python3 exeicons.py
+#
+# Every icon is the same picture so the tests can check any of them:
+# the left half is red, the right half is blue and the top left pixel is transparent
+import struct
+import zlib
+
+RT_ICON = 3
+RT_GROUP_ICON = 14
+SECTION_RVA = 0x1000
+SUBDIRECTORY = 0x80000000
+
+
+def color(x, width):
+    return (255, 0, 0) if x < width // 2 else (0, 0, 255)
+
+
+def png(size):
+    rows = b""
+    for y in range(size):
+        rows += b"\x00"
+        for x in range(size):
+            r, g, b = color(x, size)
+            rows += bytes([r, g, b, 0 if (x, y) == (0, 0) else 255])
+
+    def chunk(kind, data):
+        return struct.pack(">I", len(data)) + kind + data + struct.pack(">I", zlib.crc32(kind + data))
+
+    header = struct.pack(">IIBBBBB", size, size, 8, 6, 0, 0, 0)
+    return b"\x89PNG\r\n\x1a\n" + chunk(b"IHDR", header) + chunk(b"IDAT", zlib.compress(rows)) + chunk(b"IEND", b"")
+
+
+def dib(size, bits, alpha=False):
+    """A bitmap as stored in an icon, bottom up with the 1 bit mask after the colours
+    32 bit bitmaps without alpha leave it at 0 so the mask is used"""
+    palette = b""
+    if bits == 8:
+        # black, red and blue
+        palette = struct.pack("<BBBB", 0, 0, 0, 0) + struct.pack("<BBBB", 0, 0, 255, 0) + struct.pack("<BBBB", 255, 0, 0, 0)
+    header = struct.pack("<IiiHHIIiiII", 40, size, size * 2, 1, bits, 0, 0, 0, 0, len(palette) // 4, 0)
+    stride = (size * bits + 31) // 32 * 4
+    mask_stride = (size + 31) // 32 * 4
+    pixels = b""
+    mask = b""
+    for y in reversed(range(size)):
+        row = b""
+        for x in range(size):
+            r, g, b = color(x, size)
+            if bits == 8:
+                row += bytes([1 if r else 2])
+            elif bits == 24:
+                row += bytes([b, g, r])
+            else:
+                a = 0
+                if alpha:
+                    a = 0 if (x, y) == (0, 0) else 255
+                row += bytes([b, g, r, a])
+        pixels += row + b"\x00" * (stride - len(row))
+        # icons with alpha get a mask that hides everything to show it is ignored
+        mask_row = bytearray(mask_stride)
+        for x in range(size):
+            if alpha or (x, y) == (0, 0):
+                mask_row[x // 8] |= 0x80 >> (x % 8)
+        mask += bytes(mask_row)
+    return header + palette + pixels + mask
+
+
+def group(entries):
+    """entries are (id, size, bits, data)"""
+    out = struct.pack("<HHH", 0, 1, len(entries))
+    for id, size, bits, data in entries:
+        out += struct.pack("<BBBBHHIH", size % 256, size % 256, 0, 0, 1, bits, len(data), id)
+    return out
+
+
+def resources(tree, root_count=None):
+    """Lays out a resource directory
+    tree is a list of (name, node) where node is bytes for a resource, a list for a directory
+    or an int for an entry that points at the directory at that offset, used for loops"""
+    dirs = []
+    leaves = []
+
+    def collect(entries):
+        dirs.append(entries)
+        for _, node in entries:
+            if isinstance(node, list):
+                collect(node)
+            elif isinstance(node, bytes):
+                leaves.append(node)
+
+    collect(tree)
+    offsets = {}
+    position = 0
+    for entries in dirs:
+        offsets[id(entries)] = position
+        position += 16 + 8 * len(entries)
+    leaf_offsets = []
+    for _ in leaves:
+        leaf_offsets.append(position)
+        position += 16
+    data_offsets = []
+    for leaf in leaves:
+        data_offsets.append(position)
+        position += len(leaf) + (-len(leaf)) % 8
+
+    buf = bytearray(position)
+    leaf_index = 0
+    for entries in dirs:
+        offset = offsets[id(entries)]
+        count = len(entries)
+        if entries is tree and root_count is not None:
+            count = root_count
+        struct.pack_into("<IIHHHH", buf, offset, 0, 0, 0, 0, 0, count)
+        for index, (name, node) in enumerate(entries):
+            if isinstance(node, list):
+                target = SUBDIRECTORY | offsets[id(node)]
+            elif isinstance(node, int):
+                target = SUBDIRECTORY | node
+            else:
+                target = leaf_offsets[leaf_index]
+                struct.pack_into("<IIII", buf, target, SECTION_RVA + data_offsets[leaf_index], len(node), 0, 0)
+                buf[data_offsets[leaf_index]:data_offsets[leaf_index] + len(node)] = node
+                leaf_index += 1
+            struct.pack_into("<II", buf, offset + 16 + 8 * index, name, target)
+    return bytes(buf)
+
+
+def icon_tree(icons, groups):
+    """icons and groups map ids to data, every resource gets one language"""
+    tree = []
+    if icons:
+        tree.append((RT_ICON, [(id, [(1033, data)]) for id, data in sorted(icons.items())]))
+    if groups:
+        tree.append((RT_GROUP_ICON, [(id, [(1033, data)]) for id, data in sorted(groups.items())]))
+    return tree
+
+
+def executable(rsrc):
+    size = (len(rsrc) + 0x1ff) // 0x200 * 0x200
+    rsrc += b"\x00" * (size - len(rsrc))
+    dos = b"MZ" + b"\x00" * 58 + struct.pack("<I", 64)
+    coff = struct.pack("<HHIIIHH", 0x8664, 1, 0, 0, 0, 240, 0x22)
+    optional = struct.pack("<HBBIIIII", 0x20b, 0, 0, 0, 0, 0, 0, 0)
+    optional += struct.pack("<QIIHHHHHHIIIIHHQQQQII", 0x140000000, 0x1000, 0x200, 6, 0, 0, 0, 6, 0, 0, 0x2000, 0x200, 0, 3, 0, 0x100000, 0x1000, 0x100000, 0x1000, 0, 16)
+    directories = [(0, 0)] * 16
+    directories[2] = (SECTION_RVA, len(rsrc))
+    optional += b"".join(struct.pack("<II", *d) for d in directories)
+    section = struct.pack("<8sIIIIIIHHI", b".rsrc", size, SECTION_RVA, size, 0x200, 0, 0, 0, 0, 0x40000040)
+    header = dos + b"PE\x00\x00" + coff + optional + section
+    header += b"\x00" * (0x200 - len(header))
+    return header + rsrc
+
+
+def main():
+    # a group with a png and two bitmaps, icon 9 is not in the group and bigger than all of them
+    icons = {1: dib(16, 8), 2: png(48), 3: dib(32, 24), 9: dib(64, 24)}
+    main_group = group([(1, 16, 8, icons[1]), (2, 48, 32, icons[2]), (3, 32, 24, icons[3])])
+    with open("group.exe", "wb") as f:
+        f.write(executable(resources(icon_tree(icons, {1: main_group}))))
+
+    # no group so every image is used, the 32 bit icons are the biggest
+    icons = {1: dib(16, 8), 2: dib(24, 24), 3: dib(32, 32), 4: dib(32, 32, alpha=True)}
+    with open("icononly.exe", "wb") as f:
+        f.write(executable(resources(icon_tree(icons, {}))))
+
+    # the name directory of the icons has an entry that points back at the root
+    icons = {1: dib(16, 8)}
+    tree = icon_tree(icons, {1: group([(1, 16, 8, icons[1])])})
+    tree[0][1].append((2, 0))
+    with open("loop.exe", "wb") as f:
+        f.write(executable(resources(tree)))
+
+    # the root directory claims far more entries than the section holds
+    with open("truncated.exe", "wb") as f:
+        f.write(executable(resources(icon_tree(icons, {1: group([(1, 16, 8, icons[1])])}), root_count=0x7fff)))
+
+
+if __name__ == "__main__":
+    main()